@@ -1,24 +1,58 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"flag"
-	"log"
+	"fmt"
+	"image/color"
+	"os"
+	"os/signal"
 	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/weqqr/panorama/pkg/config"
 	"github.com/weqqr/panorama/pkg/game"
+	"github.com/weqqr/panorama/pkg/logging"
+	"github.com/weqqr/panorama/pkg/raster"
 	"github.com/weqqr/panorama/pkg/render"
 	"github.com/weqqr/panorama/pkg/render/isometric"
+	"github.com/weqqr/panorama/pkg/render/topdown"
+	"github.com/weqqr/panorama/pkg/spatial"
 	"github.com/weqqr/panorama/pkg/tile"
 	"github.com/weqqr/panorama/pkg/web"
 	"github.com/weqqr/panorama/pkg/world"
 )
 
 type Args struct {
-	FullRender bool
-	Downscale  bool
-	Serve      bool
-	ConfigPath string
+	FullRender  bool
+	Downscale   bool
+	Serve       bool
+	Live        bool
+	Verbose     bool
+	Leaflet     bool
+	Incremental bool
+	Resume      bool
+	Metrics     bool
+	Prefetch    bool
+	Slice       string
+	Min         string
+	Max         string
+	NodeStats   string
+	Export      string
+	Schematic   string
+	ConfigPath  string
+
+	// Settings overriding the config file
+	Workers   int
+	Mode      string
+	WorldPath string
+	TilesPath string
+	LogLevel  string
 }
 
 var args Args
@@ -27,54 +61,418 @@ func init() {
 	flag.BoolVar(&args.FullRender, "fullrender", false, "Render entire map")
 	flag.BoolVar(&args.Downscale, "downscale", false, "Downscale existing tiles (--fullrender does this automatically)")
 	flag.BoolVar(&args.Serve, "serve", false, "Serve tiles over the web")
+	flag.BoolVar(&args.Live, "live", false, "Serve tiles over the web, rendering them when they're requested, with a Leaflet page at the root")
+	flag.BoolVar(&args.Incremental, "incremental", false, "Render only tiles containing blocks saved since the previous render (use with --fullrender)")
+	flag.BoolVar(&args.Resume, "resume", false, "Skip tiles saved by an interrupted render (use with --fullrender)")
+	flag.BoolVar(&args.Prefetch, "prefetch", false, "Load blocks of upcoming tiles in the background while rendering (use with --fullrender)")
+	flag.StringVar(&args.Slice, "slice", "", "Render only nodes with Y between `min,max`, as if everything above were air (overrides region.y_bounds)")
+	flag.StringVar(&args.Min, "min", "", "Minimum corner of the region as node coordinates `x,y,z` (overrides region.*_bounds.min)")
+	flag.StringVar(&args.Max, "max", "", "Maximum corner of the region as node coordinates `x,y,z` (overrides region.*_bounds.max)")
+	flag.StringVar(&args.NodeStats, "nodestats", "", "Count nodes of every kind in the region and save the counts to `file` (CSV, or JSON if it ends with .json)")
+	flag.StringVar(&args.Export, "export", "", "Save all nodes in the region except air to `file` as JSON records with world coordinates")
+	flag.StringVar(&args.Schematic, "schematic", "", "Save the region to `file` as a Minetest schematic (.mts)")
+	flag.BoolVar(&args.Leaflet, "leaflet", false, "Write a standalone Leaflet page showing the tiles to the tile directory")
+	flag.BoolVar(&args.Metrics, "metrics", false, "Count block reads, cache lookups and decoding time, log the totals when done and serve them at /metrics with --live")
+	flag.BoolVar(&args.Verbose, "verbose", false, "Log additional details, such as overridden media files (same as --loglevel debug)")
 	flag.StringVar(&args.ConfigPath, "config", "config.toml", "Path to config file")
+	flag.IntVar(&args.Workers, "workers", 0, "Number of tiles rendered in parallel (overrides renderer.workers)")
+	flag.StringVar(&args.Mode, "mode", "", "Map style, `isometric`, `topdown` or `heightmap` (overrides renderer.mode)")
+	flag.StringVar(&args.WorldPath, "world", "", "Path to the world `directory` (overrides system.world_path)")
+	flag.StringVar(&args.LogLevel, "loglevel", "", "Least important messages logged, `debug`, `info`, `warn` or `error` (overrides system.log_level)")
+	flag.StringVar(&args.TilesPath, "tiles", "", "Path to the tile `directory` (overrides system.tiles_path)")
 	flag.Parse()
 }
 
-func main() {
-	log.Printf("Config path: `%v`", args.ConfigPath)
-	config, err := config.LoadConfig(args.ConfigPath)
+// setupRenderer returns the tiles covering the configured region and a
+// function creating renderers for the configured render mode
+func setupRenderer(config config.Config, g *game.Game) (spatial.TileRegion, tile.CreateRendererFunc, error) {
+	switch config.Renderer.Mode {
+	case "", "isometric":
+		options := render.DefaultOptions()
+
+		options.AmbientOcclusion = config.Renderer.AmbientOcclusion
+		options.FullBright = config.Renderer.FullBright
+		options.LightGamma = config.Renderer.LightGamma
+
+		if config.Renderer.UnknownNodeColor != "" {
+			unknownNodeColor, err := game.ParseColor(config.Renderer.UnknownNodeColor)
+			if err != nil {
+				return spatial.TileRegion{}, nil, err
+			}
+			g.SetUnknownNodeColor(unknownNodeColor)
+		}
+
+		if shading := config.Renderer.FaceShading; shading != nil {
+			options.FaceShading = render.FaceShading{
+				Top:   shading.Top,
+				Left:  shading.Left,
+				Right: shading.Right,
+			}
+		}
+
+		return isometric.ProjectRegion(config.Region), func() render.Renderer {
+			return isometric.NewRenderer(config.Region, g, options)
+		}, nil
+	case "topdown":
+		colors, err := game.LoadColorsTxt(config.Renderer.ColorsPath)
+		if err != nil {
+			return spatial.TileRegion{}, nil, err
+		}
+
+		defaultColor := color.NRGBA{R: 255, G: 0, B: 255, A: 255}
+		if config.Renderer.DefaultColor != "" {
+			defaultColor, err = game.ParseColor(config.Renderer.DefaultColor)
+			if err != nil {
+				return spatial.TileRegion{}, nil, err
+			}
+		}
+
+		return topdown.ProjectRegion(config.Region), func() render.Renderer {
+			return topdown.NewRenderer(config.Region, colors, defaultColor, config.Renderer.HeightShading, nil)
+		}, nil
+	case "heightmap":
+		return topdown.ProjectRegion(config.Region), func() render.Renderer {
+			return topdown.NewHeightmapRenderer(config.Region, config.Renderer.HeightmapColormap)
+		}, nil
+	default:
+		return spatial.TileRegion{}, nil, fmt.Errorf("unknown render mode: `%v`", config.Renderer.Mode)
+	}
+}
+
+// applyFlags replaces settings from the config file with the ones given on
+// the command line
+func applyFlags(config *config.Config) error {
+	// Flags are visited in lexicographical order, so the first error is kept
+	// rather than letting a later flag hide it
+	var err error
+	keepFirst := func(e error) {
+		if err == nil {
+			err = e
+		}
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "workers":
+			config.Renderer.Workers = args.Workers
+		case "mode":
+			config.Renderer.Mode = args.Mode
+		case "world":
+			config.System.WorldPath = args.WorldPath
+		case "tiles":
+			config.System.TilesPath = args.TilesPath
+		case "loglevel":
+			config.System.LogLevel = args.LogLevel
+		case "verbose":
+			if args.Verbose {
+				config.System.LogLevel = logging.LevelDebug.String()
+			}
+		case "slice":
+			bounds, e := parseSlice(args.Slice)
+			if e != nil {
+				keepFirst(e)
+				return
+			}
+			config.Region.YBounds = bounds
+		case "min":
+			pos, e := spatial.ParseNodePosition(args.Min)
+			if e != nil {
+				keepFirst(e)
+				return
+			}
+			config.Region.XBounds.Min = pos.X
+			config.Region.YBounds.Min = pos.Y
+			config.Region.ZBounds.Min = pos.Z
+		case "max":
+			pos, e := spatial.ParseNodePosition(args.Max)
+			if e != nil {
+				keepFirst(e)
+				return
+			}
+			config.Region.XBounds.Max = pos.X
+			config.Region.YBounds.Max = pos.Y
+			config.Region.ZBounds.Max = pos.Z
+		}
+	})
+
+	return err
+}
+
+// parseSlice parses Y bounds of a horizontal slice written as `min,max`
+func parseSlice(slice string) (spatial.Bounds, error) {
+	var bounds spatial.Bounds
+
+	parts := strings.Split(slice, ",")
+	if len(parts) != 2 {
+		return bounds, fmt.Errorf("invalid slice: `%v`", slice)
+	}
+
+	var err error
+	bounds.Min, err = strconv.Atoi(strings.TrimSpace(parts[0]))
 	if err != nil {
-		log.Fatalf("Unable to load config: %v\n", err)
+		return bounds, fmt.Errorf("invalid slice: `%v`", slice)
 	}
 
-	log.Printf("Game path: `%v`\n", config.System.GamePath)
+	bounds.Max, err = strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil || bounds.Max < bounds.Min {
+		return bounds, fmt.Errorf("invalid slice: `%v`", slice)
+	}
+
+	return bounds, nil
+}
+
+// loadGame loads node definitions of the world and media of the game
+func loadGame(config config.Config) (game.Game, error) {
+	logging.Infof("Game path: `%v`\n", config.System.GamePath)
 
 	descPath := path.Join(config.System.WorldPath, "nodes_dump.json")
-	log.Printf("Game description: `%v`\n", descPath)
+	logging.Infof("Game description: `%v`\n", descPath)
 
-	game, err := game.LoadGame(descPath, config.System.GamePath)
-	if err != nil {
-		log.Fatalf("Unable to load game description: %v\n", err)
+	mediaPaths := append([]string{config.System.GamePath}, config.System.MediaPaths...)
+	return game.LoadGame(descPath, mediaPaths)
+}
+
+// leafletProjection returns whether tiles use the isometric projection, and
+// the width of a node in rendered tiles
+func leafletProjection(config config.Config) (bool, int) {
+	// Topdown tiles and heightmaps have one pixel per node
+	if config.Renderer.Mode != "isometric" {
+		return false, 1
+	}
+
+	return true, render.BaseResolution
+}
+
+// logProgress logs progress of a full render every time another percent of
+// tiles is done
+func logProgress() tile.ProgressFunc {
+	lastPercent := -1
+	return func(done, total int) {
+		percent := 100
+		if total > 0 {
+			percent = done * 100 / total
+		}
+
+		if percent == lastPercent {
+			return
+		}
+
+		lastPercent = percent
+		logging.Infof("Rendered %v/%v tiles (%v%%)", done, total, percent)
 	}
+}
+
+// interruptContext returns a context that's cancelled when the process is
+// interrupted, so that renders can stop cleanly. A second interrupt, or one
+// after release is called, terminates the process as usual.
+func interruptContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
 
-	backend, err := world.NewPostgresBackend(config.System.WorldDSN)
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		if _, ok := <-interrupts; !ok {
+			return
+		}
+
+		signal.Stop(interrupts)
+		logging.Warnf("Interrupted, finishing tiles being saved (interrupt again to quit immediately)")
+		cancel()
+	}()
+
+	release := func() {
+		signal.Stop(interrupts)
+		close(interrupts)
+	}
+
+	return ctx, release
+}
+
+func main() {
+	logging.Infof("Config path: `%v`", args.ConfigPath)
+	config, err := config.LoadConfig(args.ConfigPath)
 	if err != nil {
-		log.Fatalf("Unable to connect to world DB: %v\n", err)
+		logging.Fatalf("Unable to load config: %v\n", err)
 	}
 
-	world := world.NewWorldWithBackend(backend)
+	if err := applyFlags(&config); err != nil {
+		logging.Fatalf("Invalid arguments: %v\n", err)
+	}
 
-	tiler := tile.NewTiler(config.Region, config.Renderer.ZoomLevels, config.System.TilesPath)
+	if err := config.Validate(); err != nil {
+		logging.Fatalf("Invalid config: %v\n", err)
+	}
+
+	// Validate has checked the level already
+	logLevel, _ := logging.ParseLevel(config.System.LogLevel)
+	logging.SetLevel(logLevel)
+
+	if config.Renderer.Workers == 0 {
+		config.Renderer.Workers = runtime.NumCPU()
+	}
+
+	var counters *world.Counters
+	if args.Metrics {
+		counters = &world.Counters{}
+		world.SetMetrics(counters)
+	}
+
+	var backend world.Backend
+	if config.System.WorldDSN != "" {
+		postgres, err := world.NewPostgresBackend(config.System.WorldDSN, config.Renderer.Workers, config.Postgres)
+		if err != nil {
+			logging.Fatalf("Unable to connect to world DB: %v\n", err)
+		}
+		backend = postgres
+	} else {
+		// Without an explicit DSN, use whatever backend world.mt specifies
+		backend, err = world.OpenBackend(config.System.WorldPath, config.Renderer.Workers, config.Postgres)
+		if err != nil {
+			logging.Fatalf("Unable to open world: %v\n", err)
+		}
+	}
+
+	if config.Retry.MaxAttempts > 1 {
+		backend = world.NewRetryingBackend(backend, config.Retry.Policy())
+	}
+
+	// Cached blocks skip retries as well
+	if config.Cache.Size > 0 {
+		cached, err := world.NewCachingBackend(backend, config.Cache.Size)
+		if err != nil {
+			logging.Fatalf("Unable to create block cache: %v\n", err)
+		}
+		backend = cached
+	}
+
+	defer backend.Close()
+
+	openedWorld := world.NewWorldWithBackend(backend)
+	w := &openedWorld
+
+	ctx, releaseInterrupts := interruptContext()
+
+	if args.NodeStats != "" {
+		logging.Infof("Counting nodes in region %v", config.Region)
+
+		counts, err := w.CountNodes(ctx, config.Region)
+		if err != nil {
+			logging.Fatalf("Unable to count nodes: %v\n", err)
+		}
+
+		err = writeNodeStats(args.NodeStats, counts)
+		if err != nil {
+			logging.Fatalf("Unable to save node statistics: %v\n", err)
+		}
+
+		logging.Infof("Saved counts of %v nodes to `%v`", len(counts), args.NodeStats)
+	}
+
+	if args.Export != "" {
+		logging.Infof("Exporting nodes in region %v", config.Region)
+
+		err := exportNodes(ctx, w, config.Region, args.Export)
+		if err != nil {
+			logging.Fatalf("Unable to export nodes: %v\n", err)
+		}
+	}
+
+	if args.Schematic != "" {
+		logging.Infof("Saving region %v as a schematic", config.Region)
+
+		err := exportSchematic(ctx, w, config.Region, args.Schematic)
+		if err != nil {
+			logging.Fatalf("Unable to save schematic: %v\n", err)
+		}
+	}
+
+	tileFormat, err := raster.ParseImageFormat(config.Renderer.TileFormat)
+	if err != nil {
+		logging.Fatalf("Unable to set up tile format: %v\n", err)
+	}
+
+	tiler := tile.NewTiler(config.Region, config.Renderer.ZoomLevels, config.Renderer.TileSize, config.System.TilesPath, tileFormat, config.Renderer.TileQuality)
 
 	if args.FullRender {
-		log.Printf("Performing a full render using %v workers", config.Renderer.Workers)
-		tileRegion := isometric.ProjectRegion(config.Region)
+		game, err := loadGame(config)
+		if err != nil {
+			logging.Fatalf("Unable to load game description: %v\n", err)
+		}
 
-		log.Printf("Region: %v", config.Region)
-		log.Printf("TileRegion: %v", tileRegion)
+		logging.Infof("Performing a full render using %v workers", config.Renderer.Workers)
 
-		tiler.FullRender(&game, &world, config.Renderer.Workers, tileRegion, func() render.Renderer {
-			return isometric.NewRenderer(config.Region, &game)
+		tileRegion, createRenderer, err := setupRenderer(config, &game)
+		if err != nil {
+			logging.Fatalf("Unable to set up renderer: %v\n", err)
+		}
+
+		logging.Infof("Region: %v", config.Region)
+		logging.Infof("TileRegion: %v", tileRegion)
+
+		tiler.FullRender(ctx, &game, w, config.Renderer.Workers, tileRegion, createRenderer, tile.FullRenderOptions{
+			Incremental: args.Incremental,
+			Resume:      args.Resume,
+			Prefetch:    args.Prefetch,
+			OnProgress:  logProgress(),
 		})
+
+		if missing := game.MissingMedia(); len(missing) > 0 {
+			logging.Warnf("Missing media files (%v): %v", len(missing), strings.Join(missing, ", "))
+		}
+	}
+
+	if counters != nil {
+		logging.Infof("Block metrics: %v", counters.Totals())
+	}
+
+	if ctx.Err() != nil {
+		logging.Warnf("Render interrupted, run it again with --resume to continue")
+		return
 	}
 
 	if args.Downscale || args.FullRender {
 		tiler.DownscaleTiles()
 	}
 
+	if args.Leaflet {
+		isometric, nodeSize := leafletProjection(config)
+		err := tiler.WriteLeafletPage(config.Web.Title, isometric, nodeSize)
+		if err != nil {
+			logging.Fatalf("Unable to write Leaflet page: %v\n", err)
+		}
+	}
+
+	// Serving runs until the process is terminated
+	releaseInterrupts()
+
+	if args.Live {
+		game, err := loadGame(config)
+		if err != nil {
+			logging.Fatalf("Unable to load game description: %v\n", err)
+		}
+
+		tileRegion, createRenderer, err := setupRenderer(config, &game)
+		if err != nil {
+			logging.Fatalf("Unable to set up renderer: %v\n", err)
+		}
+
+		maxAge := time.Duration(config.Web.TileMaxAge) * time.Second
+		live := tile.NewLiveTiler(&tiler, &game, w, config.Renderer.Workers, tileRegion, createRenderer, maxAge)
+
+		var page bytes.Buffer
+		isometric, nodeSize := leafletProjection(config)
+		err = tiler.LeafletPage(&page, config.Web.Title, isometric, nodeSize)
+		if err != nil {
+			logging.Fatalf("Unable to create Leaflet page: %v\n", err)
+		}
+
+		logging.Infof("Serving tiles rendered on demand @ %v", config.Web.ListenAddress)
+		web.ServeLive(&config, live, page.Bytes(), counters)
+	}
+
 	if args.Serve {
-		log.Printf("Serving tiles @ %v", config.Web.ListenAddress)
+		logging.Infof("Serving tiles @ %v", config.Web.ListenAddress)
 		web.Serve(&config)
 	}
 }
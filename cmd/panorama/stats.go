@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/weqqr/panorama/pkg/spatial"
+	"github.com/weqqr/panorama/pkg/world"
+)
+
+type nodeCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// writeNodeStats saves node counts to path, most common nodes first. Files
+// with the `.json` extension get a JSON array, anything else is written as
+// CSV.
+func writeNodeStats(path string, counts map[string]int) error {
+	sorted := make([]nodeCount, 0, len(counts))
+	for name, count := range counts {
+		sorted = append(sorted, nodeCount{Name: name, Count: count})
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Count != sorted[j].Count {
+			return sorted[i].Count > sorted[j].Count
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if filepath.Ext(path) == ".json" {
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(sorted); err != nil {
+			return err
+		}
+
+		return file.Close()
+	}
+
+	writer := csv.NewWriter(file)
+	if err := writer.Write([]string{"name", "count"}); err != nil {
+		return err
+	}
+
+	for _, node := range sorted {
+		if err := writer.Write([]string{node.Name, strconv.Itoa(node.Count)}); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// exportNodes saves nodes inside region to path, see World.ExportNodes
+func exportNodes(ctx context.Context, w *world.World, region spatial.Region, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := w.ExportNodes(ctx, region, file); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// exportSchematic saves the region to path, see World.ExportSchematic
+func exportSchematic(ctx context.Context, w *world.World, region spatial.Region, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := w.ExportSchematic(ctx, region, file); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
@@ -4,9 +4,13 @@ go 1.16
 
 require (
 	github.com/BurntSushi/toml v1.1.0
+	github.com/chai2010/webp v1.1.0
 	github.com/gofiber/fiber/v2 v2.34.1
+	github.com/gomodule/redigo v1.8.9
 	github.com/hashicorp/golang-lru v0.5.4
 	github.com/jackc/pgx/v4 v4.16.1
 	github.com/klauspost/compress v1.15.7
+	github.com/mattn/go-sqlite3 v1.14.14
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
+	github.com/syndtr/goleveldb v1.0.0
 )
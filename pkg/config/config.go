@@ -1,39 +1,151 @@
 package config
 
 import (
+	"fmt"
 	"io"
 	"os"
+	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/weqqr/panorama/pkg/game"
+	"github.com/weqqr/panorama/pkg/logging"
+	"github.com/weqqr/panorama/pkg/raster"
 	"github.com/weqqr/panorama/pkg/spatial"
+	"github.com/weqqr/panorama/pkg/world"
 )
 
 type Web struct {
 	ListenAddress string `toml:"listen_address"`
 	Title         string `toml:"title"`
+	// TileMaxAge is the number of seconds tiles rendered on demand are reused
+	// before they're rendered again. If it's 0, they're never rendered again.
+	TileMaxAge int `toml:"tile_max_age"`
 }
 
 type Renderer struct {
 	Workers    int `toml:"workers"`
 	ZoomLevels int `toml:"zoom_levels"`
+	// TileSize is the width and height of saved tiles in pixels. Rendered
+	// tiles are resized to it.
+	TileSize int `toml:"tile_size"`
+	// TileFormat is the image format of tiles, `png`, `jpeg` or `webp`, and
+	// TileQuality is the quality of JPEG and WebP tiles from 1 to 100
+	TileFormat  string `toml:"tile_format"`
+	TileQuality int    `toml:"tile_quality"`
+
+	// Mode selects the map style, `isometric`, `topdown` or `heightmap`
+	Mode string `toml:"mode"`
+	// AmbientOcclusion is the strength of ambient occlusion in the isometric
+	// mode, from 0 to 1
+	AmbientOcclusion float64 `toml:"ambient_occlusion"`
+	// FaceShading sets brightness of top and side faces in the isometric mode.
+	// If it's omitted, all faces keep their brightness.
+	FaceShading *FaceShading `toml:"face_shading"`
+	// FullBright disables shading by light level, and LightGamma changes the
+	// light curve, in the isometric mode
+	FullBright bool    `toml:"full_bright"`
+	LightGamma float64 `toml:"light_gamma"`
+	// UnknownNodeColor is the color of nodes missing from the game in the
+	// isometric mode. If it's empty, they aren't drawn.
+	UnknownNodeColor string `toml:"unknown_node_color"`
+	// ColorsPath, DefaultColor and HeightShading are used by the topdown mode
+	ColorsPath    string `toml:"colors_path"`
+	DefaultColor  string `toml:"default_color"`
+	HeightShading bool   `toml:"height_shading"`
+	// HeightmapColormap colors heights in the heightmap mode from blue
+	// through green to white instead of drawing them in grayscale
+	HeightmapColormap bool `toml:"heightmap_colormap"`
+}
+
+type FaceShading struct {
+	Top   float64 `toml:"top"`
+	Left  float64 `toml:"left"`
+	Right float64 `toml:"right"`
 }
 
 type System struct {
-	GamePath  string `toml:"game_path"`
-	TilesPath string `toml:"tiles_path"`
-	WorldPath string `toml:"world_path"`
-	WorldDSN  string `toml:"world_dsn"`
+	GamePath   string   `toml:"game_path"`
+	MediaPaths []string `toml:"media_paths"`
+	TilesPath  string   `toml:"tiles_path"`
+	WorldPath  string   `toml:"world_path"`
+	WorldDSN   string   `toml:"world_dsn"`
+	// LogLevel is the least important kind of messages that are logged, one
+	// of `debug`, `info`, `warn` or `error`
+	LogLevel string `toml:"log_level"`
+}
+
+// Retry describes how failed requests to the world's backend are retried
+type Retry struct {
+	// MaxAttempts is the number of times a request is made before giving up.
+	// 1 disables retrying.
+	MaxAttempts int `toml:"max_attempts"`
+	// InitialDelay and MaxDelay are in milliseconds. The delay doubles after
+	// every failed retry, up to MaxDelay, or without a limit if MaxDelay is 0.
+	InitialDelay int `toml:"initial_delay"`
+	MaxDelay     int `toml:"max_delay"`
+}
+
+// Policy returns the retry settings in the form used by world.RetryingBackend
+func (r Retry) Policy() world.RetryPolicy {
+	return world.RetryPolicy{
+		MaxAttempts:  r.MaxAttempts,
+		InitialDelay: time.Duration(r.InitialDelay) * time.Millisecond,
+		MaxDelay:     time.Duration(r.MaxDelay) * time.Millisecond,
+	}
+}
+
+// Cache describes the cache of block data read from the world's backend
+type Cache struct {
+	// Size is the number of blocks kept in the cache. Missing blocks count
+	// too. 0 disables caching.
+	Size int `toml:"size"`
 }
 
 type Config struct {
-	System   System         `toml:"system"`
-	Web      Web            `toml:"web"`
-	Renderer Renderer       `toml:"renderer"`
-	Region   spatial.Region `toml:"region"`
+	System   System               `toml:"system"`
+	Web      Web                  `toml:"web"`
+	Renderer Renderer             `toml:"renderer"`
+	Region   spatial.Region       `toml:"region"`
+	Postgres world.PostgresSchema `toml:"postgres"`
+	Retry    Retry                `toml:"retry"`
+	Cache    Cache                `toml:"cache"`
+}
+
+// DefaultConfig returns the configuration used for settings missing from
+// config files. Values match the ones documented in config.example.toml.
+func DefaultConfig() Config {
+	return Config{
+		System: System{
+			GamePath:  "/var/lib/panorama/game",
+			TilesPath: "/var/lib/panorama/tiles",
+			WorldPath: "/var/lib/panorama/world",
+			LogLevel:  "info",
+		},
+		Web: Web{
+			ListenAddress: ":33333",
+			Title:         "Server map",
+			TileMaxAge:    60,
+		},
+		Renderer: Renderer{
+			ZoomLevels:   8,
+			TileSize:     256,
+			TileFormat:   "png",
+			TileQuality:  90,
+			Mode:         "isometric",
+			DefaultColor: "#ff00ff",
+		},
+		Retry: Retry{
+			MaxAttempts:  3,
+			InitialDelay: 100,
+			MaxDelay:     5000,
+		},
+	}
 }
 
+// LoadConfig reads the config file at path. Missing settings keep their
+// values from DefaultConfig.
 func LoadConfig(path string) (Config, error) {
-	var config Config
+	config := DefaultConfig()
 
 	file, err := os.Open(path)
 	if err != nil {
@@ -55,3 +167,107 @@ func LoadConfig(path string) (Config, error) {
 
 	return config, nil
 }
+
+// Validate checks that settings have valid values and don't conflict with
+// each other, so that mistakes are reported before any work starts
+func (c *Config) Validate() error {
+	if c.System.WorldPath == "" && c.System.WorldDSN == "" {
+		return fmt.Errorf("either system.world_path or system.world_dsn must be set")
+	}
+
+	if c.System.TilesPath == "" {
+		return fmt.Errorf("system.tiles_path must be set")
+	}
+
+	if _, err := logging.ParseLevel(c.System.LogLevel); err != nil {
+		return fmt.Errorf("system.log_level: %w", err)
+	}
+
+	if c.Web.TileMaxAge < 0 {
+		return fmt.Errorf("web.tile_max_age must not be negative, got `%v`", c.Web.TileMaxAge)
+	}
+
+	if c.Retry.MaxAttempts < 1 {
+		return fmt.Errorf("retry.max_attempts must be at least 1, got `%v`", c.Retry.MaxAttempts)
+	}
+
+	if c.Retry.InitialDelay < 0 || c.Retry.MaxDelay < 0 {
+		return fmt.Errorf("retry delays must not be negative, got %+v", c.Retry)
+	}
+
+	if c.Cache.Size < 0 {
+		return fmt.Errorf("cache.size must not be negative, got `%v`", c.Cache.Size)
+	}
+
+	for _, axis := range []struct {
+		name   string
+		bounds spatial.Bounds
+	}{
+		{"x_bounds", c.Region.XBounds},
+		{"y_bounds", c.Region.YBounds},
+		{"z_bounds", c.Region.ZBounds},
+	} {
+		if axis.bounds.Max < axis.bounds.Min {
+			return fmt.Errorf("region.%v: max must not be less than min, got %v", axis.name, axis.bounds)
+		}
+	}
+
+	return c.Renderer.validate()
+}
+
+func (r *Renderer) validate() error {
+	if r.Workers < 0 {
+		return fmt.Errorf("renderer.workers must not be negative, got `%v`", r.Workers)
+	}
+
+	if r.ZoomLevels < 0 {
+		return fmt.Errorf("renderer.zoom_levels must not be negative, got `%v`", r.ZoomLevels)
+	}
+
+	if r.TileSize <= 0 || r.TileSize%2 != 0 {
+		return fmt.Errorf("renderer.tile_size must be a positive even number, got `%v`", r.TileSize)
+	}
+
+	if _, err := raster.ParseImageFormat(r.TileFormat); err != nil {
+		return fmt.Errorf("renderer.tile_format: %w", err)
+	}
+
+	if r.TileQuality < 1 || r.TileQuality > 100 {
+		return fmt.Errorf("renderer.tile_quality must be between 1 and 100, got `%v`", r.TileQuality)
+	}
+
+	switch r.Mode {
+	case "isometric":
+		if r.AmbientOcclusion < 0 || r.AmbientOcclusion > 1 {
+			return fmt.Errorf("renderer.ambient_occlusion must be between 0 and 1, got `%v`", r.AmbientOcclusion)
+		}
+
+		if r.LightGamma < 0 {
+			return fmt.Errorf("renderer.light_gamma must not be negative, got `%v`", r.LightGamma)
+		}
+
+		if s := r.FaceShading; s != nil && (s.Top < 0 || s.Left < 0 || s.Right < 0) {
+			return fmt.Errorf("renderer.face_shading must not be negative, got %+v", *s)
+		}
+
+		if r.UnknownNodeColor != "" {
+			if _, err := game.ParseColor(r.UnknownNodeColor); err != nil {
+				return fmt.Errorf("renderer.unknown_node_color: %w", err)
+			}
+		}
+	case "topdown":
+		if r.ColorsPath == "" {
+			return fmt.Errorf("renderer.colors_path must be set in the topdown mode")
+		}
+
+		if _, err := game.ParseColor(r.DefaultColor); err != nil {
+			return fmt.Errorf("renderer.default_color: %w", err)
+		}
+	case "heightmap":
+		// Heightmaps have no settings that could be invalid
+	default:
+		return fmt.Errorf("renderer.mode must be `isometric`, `topdown` or `heightmap`, got `%v`", r.Mode)
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package game
+
+import (
+	"bufio"
+	"fmt"
+	"image/color"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// LoadColorsTxt loads node colors from a file in minetestmapper's colors.txt
+// format, where each line is `node_name R G B [A [T]]`. The T column is
+// ignored.
+func LoadColorsTxt(path string) (map[string]color.NRGBA, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	colors := make(map[string]color.NRGBA)
+
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+
+		// Skip comments and empty lines
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 4 || len(fields) > 6 {
+			return nil, fmt.Errorf("%v:%v: expected 4 to 6 fields, got %v", path, lineNumber, len(fields))
+		}
+
+		components := []uint8{0, 0, 0, 255}
+		for i, field := range fields[1:] {
+			if i >= len(components) {
+				break
+			}
+
+			value, err := strconv.ParseUint(field, 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("%v:%v: invalid color component `%v`", path, lineNumber, field)
+			}
+			components[i] = uint8(value)
+		}
+
+		colors[fields[0]] = color.NRGBA{
+			R: components[0],
+			G: components[1],
+			B: components[2],
+			A: components[3],
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return colors, nil
+}
@@ -3,8 +3,12 @@ package game
 import (
 	"encoding/json"
 	"image"
+	"image/color"
+	"math"
 	"os"
+	"sync"
 
+	"github.com/weqqr/panorama/pkg/logging"
 	"github.com/weqqr/panorama/pkg/mesh"
 )
 
@@ -19,65 +23,240 @@ type NodeDefinition struct {
 	ParamType2 ParamType2
 	Textures   []*image.NRGBA
 	Model      *mesh.Model
+	Palette    []color.NRGBA
+	AlphaMode  AlphaMode
+	// Color tints textures of the node. It's nil if the node isn't tinted.
+	Color *color.NRGBA
+	// NodeBox and Leveled are kept for leveled node boxes, which change shape
+	// with param2
+	NodeBox *NodeBox
+	Leveled int
+}
+
+// leveledMask selects the level of leveled node boxes from param2
+const leveledMask = 0x7F
+
+// NodeBoxModel returns the model of the node with the given param2. Only
+// leveled node boxes depend on param2; their boxes have the top raised to the
+// level, measured in 1/64 of a node.
+func (nd *NodeDefinition) NodeBoxModel(param2 uint8) *mesh.Model {
+	if nd.NodeBox == nil || nd.NodeBox.Type != "leveled" {
+		return nd.Model
+	}
+
+	level := nd.Leveled
+	if nd.ParamType2 == ParamType2Leveled && param2&leveledMask != 0 {
+		level = int(param2 & leveledMask)
+	}
+
+	boxes := make([][]float64, len(nd.NodeBox.Fixed))
+	for i, box := range nd.NodeBox.Fixed {
+		leveled := normalizeBox(box)
+		leveled[4] = -0.5 + float64(level)/64
+		boxes[i] = leveled
+	}
+
+	return nodeBoxModel(boxes)
+}
+
+// normalizeBox returns a copy of box with minimum coordinates first
+func normalizeBox(box []float64) []float64 {
+	normalized := make([]float64, 6)
+	for axis := 0; axis < 3; axis++ {
+		normalized[axis] = math.Min(box[axis], box[axis+3])
+		normalized[axis+3] = math.Max(box[axis], box[axis+3])
+	}
+	return normalized
+}
+
+func nodeBoxModel(boxes [][]float64) *mesh.Model {
+	model := mesh.NewModel()
+	for _, box := range boxes {
+		box = normalizeBox(box)
+		model.Meshes = append(model.Meshes, mesh.Cuboid(box[0], box[1], box[2], box[3], box[4], box[5], mesh.CubeFaceNone)...)
+	}
+	return &model
+}
+
+// EffectiveAlphaMode returns how alpha of textures is used. Nodes that don't
+// set use_texture_alpha get the Minetest default for their drawtype: normal
+// nodes are opaque and others are clipped. Liquids are blended, because their
+// translucency usually comes from the legacy `alpha` field instead.
+func (nd *NodeDefinition) EffectiveAlphaMode() AlphaMode {
+	if nd.AlphaMode != AlphaModeDefault {
+		return nd.AlphaMode
+	}
+
+	switch {
+	case nd.DrawType == DrawTypeNormal:
+		return AlphaModeOpaque
+	case nd.DrawType.IsLiquid():
+		return AlphaModeBlend
+	default:
+		return AlphaModeClip
+	}
+}
+
+// NeedsAlphaBlending reports whether textures of the node may be partially
+// transparent
+func (nd *NodeDefinition) NeedsAlphaBlending() bool {
+	return nd.EffectiveAlphaMode() == AlphaModeBlend
+}
+
+// IsOpaqueCube reports whether the node is a full cube that can't be seen
+// through, so it completely hides faces of adjacent nodes
+func (nd *NodeDefinition) IsOpaqueCube() bool {
+	return nd.DrawType == DrawTypeNormal && nd.EffectiveAlphaMode() == AlphaModeOpaque
+}
+
+// wallMountedToFaceDir maps wallmounted values to facedir rotations placing
+// the bottom of the node against the ceiling, the floor, +X, -X, +Z and -Z
+// respectively, same as in Minetest
+var wallMountedToFaceDir = [6]uint8{20, 0, 16 + 1, 12 + 3, 8, 4 + 2}
+
+// FaceDir returns the facedir rotation of the node, ranging from 0 to 23.
+// Wallmounted nodes are converted to the equivalent facedir. It's 0 for nodes
+// that aren't rotated.
+func (nd *NodeDefinition) FaceDir(param2 uint8) uint8 {
+	switch nd.ParamType2 {
+	case ParamType2FaceDir, ParamType2ColorFaceDir:
+		// Values above 23 are invalid and treated as 0 by Minetest
+		facedir := param2 & 0x1F
+		if facedir >= 24 {
+			return 0
+		}
+		return facedir
+	case ParamType2WallMounted, ParamType2ColorWallMounted:
+		wallMounted := param2 & 0x07
+		if int(wallMounted) >= len(wallMountedToFaceDir) {
+			return 0
+		}
+		return wallMountedToFaceDir[wallMounted]
+	default:
+		return 0
+	}
+}
+
+// PaletteColor returns the palette color selected by param2. The second value
+// is false if the node isn't colored using a palette.
+func (nd *NodeDefinition) PaletteColor(param2 uint8) (color.NRGBA, bool) {
+	var index int
+	switch nd.ParamType2 {
+	case ParamType2Color:
+		index = int(param2)
+	case ParamType2ColorFaceDir, ParamType2ColorDegRotate:
+		// Lower 5 bits store rotation
+		index = int(param2 >> 5)
+	case ParamType2ColorWallMounted:
+		// Lower 3 bits store rotation
+		index = int(param2 >> 3)
+	default:
+		return color.NRGBA{}, false
+	}
+
+	if index >= len(nd.Palette) {
+		return color.NRGBA{}, false
+	}
+
+	return nd.Palette[index], true
+}
+
+// Tint returns the color textures of the node with the given param2 are
+// multiplied with. Like in Minetest, it's the palette color if the node has a
+// palette, and Color is only used for nodes without one. The second value is
+// false if the node isn't tinted.
+func (nd *NodeDefinition) Tint(param2 uint8) (color.NRGBA, bool) {
+	if c, ok := nd.PaletteColor(param2); ok {
+		return c, true
+	}
+
+	if nd.Color != nil && len(nd.Palette) == 0 {
+		return *nd.Color, true
+	}
+
+	return color.NRGBA{}, false
 }
 
 type Game struct {
 	Aliases map[string]string
 	Nodes   map[string]NodeDefinition
 	unknown NodeDefinition
-}
 
-func makeNormalNode(drawtype DrawType, tiles []*image.NRGBA) NodeDefinition {
-	textures := make([]*image.NRGBA, 6)
-	model := mesh.Cube(mesh.CubeFaceNone)
+	// unknownNodes holds names of nodes without definitions that have been
+	// logged already
+	unknownNodes *sync.Map
+
+	missingMedia []string
+}
 
+// FaceTiles assigns tiles to the faces of a cube in the order +Y, -Y, +X, -X,
+// +Z, -Z, which is the order of both node tiles and meshes of mesh.Cube. Like
+// in Minetest, missing tiles are filled with the last one, so a single tile
+// covers all faces and three tiles mean top, bottom and sides. Extra tiles
+// are ignored. Without tiles, all faces are nil.
+func FaceTiles(tiles []*image.NRGBA) [6]*image.NRGBA {
+	var faces [6]*image.NRGBA
 	if len(tiles) == 0 {
-		return NodeDefinition{
-			DrawType: drawtype,
-			Textures: textures,
-			Model:    model,
-		}
+		return faces
 	}
 
-	for i := 0; i < 6; i++ {
+	for i := range faces {
 		if i >= len(tiles) {
-			textures[i] = tiles[len(tiles)-1]
+			faces[i] = tiles[len(tiles)-1]
 			continue
 		}
 
-		textures[i] = tiles[i]
+		faces[i] = tiles[i]
 	}
 
+	return faces
+}
+
+func makeNormalNode(drawtype DrawType, tiles []*image.NRGBA) NodeDefinition {
+	faces := FaceTiles(tiles)
+
 	return NodeDefinition{
 		DrawType: drawtype,
-		Textures: textures,
-		Model:    model,
+		Textures: faces[:],
+		Model:    mesh.Cube(mesh.CubeFaceNone),
 	}
 }
 
 func makeNodeBox(nodeBox *NodeBox, tiles []*image.NRGBA) NodeDefinition {
 	textures := make([]*image.NRGBA, 6*len(nodeBox.Fixed))
-	model := mesh.NewModel()
 
 	if len(tiles) == 0 {
+		model := mesh.NewModel()
 		return NodeDefinition{
 			Textures: textures,
 			Model:    &model,
 		}
 	}
 
-	for _, box := range nodeBox.Fixed {
-		model.Meshes = append(model.Meshes, mesh.Cuboid(box[0], box[1], box[2], box[3], box[4], box[5], mesh.CubeFaceNone)...)
-	}
+	model := nodeBoxModel(nodeBox.Fixed)
 
+	// Every box is textured like a cube
+	faces := FaceTiles(tiles)
 	for i := 0; i < len(nodeBox.Fixed); i++ {
-		for j := 0; j < 6; j++ {
-			if j >= len(tiles) {
-				textures[6*i+j] = tiles[len(tiles)-1]
-				continue
-			}
+		copy(textures[6*i:], faces[:])
+	}
+
+	return NodeDefinition{
+		Textures: textures,
+		Model:    model,
+		NodeBox:  nodeBox,
+	}
+}
+
+func makePlantlikeNode(visualScale float64, tiles []*image.NRGBA) NodeDefinition {
+	model := mesh.NewModel()
+	model.Meshes = mesh.CrossedQuads(visualScale)
 
-			textures[6*i+j] = tiles[j]
+	// Both quads use the first tile
+	textures := make([]*image.NRGBA, len(model.Meshes))
+	if len(tiles) > 0 {
+		for i := range textures {
+			textures[i] = tiles[0]
 		}
 	}
 
@@ -87,7 +266,7 @@ func makeNodeBox(nodeBox *NodeBox, tiles []*image.NRGBA) NodeDefinition {
 	}
 }
 
-func makeMeshNode(model *mesh.Model, tiles []*image.NRGBA) NodeDefinition {
+func makeMeshNode(model *mesh.Model, visualScale float64, tiles []*image.NRGBA) NodeDefinition {
 	textures := make([]*image.NRGBA, len(model.Meshes))
 	if len(tiles) == 0 {
 		return NodeDefinition{
@@ -96,13 +275,30 @@ func makeMeshNode(model *mesh.Model, tiles []*image.NRGBA) NodeDefinition {
 		}
 	}
 
+	// Meshes without their own tile reuse the last one
 	for i := range model.Meshes {
 		if i >= len(tiles) {
-			break
+			textures[i] = tiles[len(tiles)-1]
+			continue
 		}
 		textures[i] = tiles[i]
 	}
 
+	// Models are shared by all nodes using the file, so they are copied
+	// instead of being scaled in place
+	if visualScale != 1 {
+		scaled := mesh.NewModel()
+		for _, m := range model.Meshes {
+			vertices := make([]mesh.Vertex, len(m.Vertices))
+			for i, vertex := range m.Vertices {
+				vertex.Position = vertex.Position.MulScalar(visualScale)
+				vertices[i] = vertex
+			}
+			scaled.Meshes = append(scaled.Meshes, mesh.Mesh{Vertices: vertices})
+		}
+		model = &scaled
+	}
+
 	return NodeDefinition{
 		Model:    model,
 		Textures: textures,
@@ -112,21 +308,26 @@ func makeMeshNode(model *mesh.Model, tiles []*image.NRGBA) NodeDefinition {
 func ResolveNode(descriptor NodeDescriptor, mediaCache *MediaCache) NodeDefinition {
 	tiles := make([]*image.NRGBA, len(descriptor.Tiles))
 
-	for i, tileName := range descriptor.Tiles {
-		tiles[i] = mediaCache.Image(tileName)
+	for i, tile := range descriptor.Tiles {
+		tiles[i] = mediaCache.ResolveTile(tile)
 	}
 
 	var nd NodeDefinition
 
 	switch descriptor.DrawType {
-	case DrawTypeNormal, DrawTypeAllFaces, DrawTypeLiquid, DrawTypeFlowingLiquid, DrawTypeGlasslike, DrawTypeGlasslikeFramed:
+	// The plant of plantlike_rooted nodes grows into the node above and
+	// doesn't fit into the image of a single node, so only the base is drawn
+	case DrawTypeNormal, DrawTypeAllFaces, DrawTypeLiquid, DrawTypeFlowingLiquid, DrawTypeGlasslike, DrawTypeGlasslikeFramed, DrawTypePlantlikeRooted:
 		nd = makeNormalNode(descriptor.DrawType, tiles)
+	case DrawTypePlantlike:
+		nd = makePlantlikeNode(descriptor.VisualScale, tiles)
 	case DrawTypeNodeBox:
 		if descriptor.NodeBox == nil {
 			break
 		}
 
 		nd = makeNodeBox(descriptor.NodeBox, tiles)
+		nd.Leveled = descriptor.Leveled
 	case DrawTypeMesh:
 		if descriptor.Mesh == nil {
 			break
@@ -134,7 +335,7 @@ func ResolveNode(descriptor NodeDescriptor, mediaCache *MediaCache) NodeDefiniti
 
         model := mediaCache.Mesh(*descriptor.Mesh)
         if model != nil {
-	        nd = makeMeshNode(model, tiles)
+	        nd = makeMeshNode(model, descriptor.VisualScale, tiles)
         }
     }
 
@@ -142,10 +343,22 @@ func ResolveNode(descriptor NodeDescriptor, mediaCache *MediaCache) NodeDefiniti
 	nd.ParamType = descriptor.ParamType
 	nd.ParamType2 = descriptor.ParamType2
 
+	nd.AlphaMode = descriptor.UseTextureAlpha
+	if descriptor.Color != nil {
+		c := color.NRGBA(*descriptor.Color)
+		nd.Color = &c
+	}
+
+	if descriptor.Palette != nil {
+		nd.Palette = mediaCache.Palette(*descriptor.Palette)
+	}
+
 	return nd
 }
 
-func LoadGame(desc string, path string) (Game, error) {
+// LoadGame loads node definitions from desc and media from mediaPaths, see
+// MediaCache.fetchMediaDirs
+func LoadGame(desc string, mediaPaths []string) (Game, error) {
 	descJSON, err := os.ReadFile(desc)
 	if err != nil {
 		return Game{}, err
@@ -159,13 +372,14 @@ func LoadGame(desc string, path string) (Game, error) {
 
 	mediaCache := NewMediaCache()
 
-	err = mediaCache.fetchGameAndMedia("/var/lib/panorama/games/minetest_game",path)
+	err = mediaCache.fetchMediaDirs(mediaPaths)
 	if err != nil {
 		return Game{}, err
 	}
 
 	nodes := make(map[string]NodeDefinition)
 	for name, gameNode := range descriptor.Nodes {
+		gameNode.Tiles = mediaCache.OverrideTiles(name, gameNode.Tiles)
 		node := ResolveNode(gameNode, mediaCache)
 
 		nodes[name] = node
@@ -179,12 +393,50 @@ func LoadGame(desc string, path string) (Game, error) {
 			Textures: []*image.NRGBA{mediaCache.dummyImage},
 			Model:    nil,
 		},
+		unknownNodes: &sync.Map{},
+		missingMedia: mediaCache.MissingMedia(),
 	}, nil
 }
 
+// NodeDef returns the definition of the named node. Nodes that the game
+// doesn't define, usually because their mod isn't loaded, get a placeholder
+// definition, and their names are logged once.
 func (g *Game) NodeDef(node string) NodeDefinition {
 	if def, ok := g.Nodes[node]; ok {
 		return def
 	}
+
+	if g.unknownNodes != nil && node != "air" && node != "ignore" {
+		if _, logged := g.unknownNodes.LoadOrStore(node, struct{}{}); !logged {
+			logging.Warnf("unknown node: %v", node)
+		}
+	}
+
 	return g.unknown
 }
+
+// SetUnknownNodeColor makes nodes without definitions drawn as solid cubes of
+// color c. By default they aren't drawn at all.
+func (g *Game) SetUnknownNodeColor(c color.NRGBA) {
+	g.unknown = ColorNode(c)
+}
+
+// ColorNode returns the definition of a cube of a single color. Translucent
+// colors are blended with nodes behind the cube.
+func ColorNode(c color.NRGBA) NodeDefinition {
+	texture := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	texture.SetNRGBA(0, 0, c)
+
+	nd := makeNormalNode(DrawTypeNormal, []*image.NRGBA{texture})
+	if c.A < 255 {
+		nd.AlphaMode = AlphaModeBlend
+	}
+
+	return nd
+}
+
+// MissingMedia lists media files referenced by nodes that weren't found while
+// loading the game
+func (g *Game) MissingMedia() []string {
+	return g.missingMedia
+}
@@ -0,0 +1,31 @@
+package game
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestNodeTint(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+	palette := []color.NRGBA{{0, 255, 0, 255}, {0, 0, 255, 255}}
+
+	for _, test := range []struct {
+		name   string
+		nd     NodeDefinition
+		param2 uint8
+		tint   color.NRGBA
+		tinted bool
+	}{
+		{"plain", NodeDefinition{}, 0, color.NRGBA{}, false},
+		{"color", NodeDefinition{Color: &red}, 0, red, true},
+		{"palette", NodeDefinition{ParamType2: ParamType2Color, Palette: palette}, 1, palette[1], true},
+		// Color doesn't apply to nodes placed with a palette
+		{"palette and color", NodeDefinition{ParamType2: ParamType2Color, Palette: palette, Color: &red}, 0, palette[0], true},
+		{"index out of palette", NodeDefinition{ParamType2: ParamType2Color, Palette: palette, Color: &red}, 5, color.NRGBA{}, false},
+	} {
+		tint, tinted := test.nd.Tint(test.param2)
+		if tint != test.tint || tinted != test.tinted {
+			t.Errorf("%v: got %v, %v, want %v, %v", test.name, tint, tinted, test.tint, test.tinted)
+		}
+	}
+}
@@ -1,21 +1,36 @@
 package game
 
 import (
+	"archive/zip"
 	"image"
 	"image/color"
+	"io"
 	"io/fs"
-	"log"
+	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 
+	"github.com/weqqr/panorama/pkg/logging"
 	"github.com/weqqr/panorama/pkg/mesh"
 	"github.com/weqqr/panorama/pkg/raster"
 )
 
 type MediaCache struct {
+	mutex      sync.RWMutex
 	images     map[string]*image.NRGBA
+	textures   map[string]*image.NRGBA
 	models     map[string]*mesh.Model
 	dummyImage *image.NRGBA
+
+	// overrides contains texture overrides for each node, in the order they
+	// should be applied
+	overrides map[string][]textureOverride
+
+	// missing contains names of media files that were requested but not found
+	missing map[string]struct{}
 }
 
 func NewMediaCache() *MediaCache {
@@ -27,58 +42,317 @@ func NewMediaCache() *MediaCache {
 
 	return &MediaCache{
 		images:     make(map[string]*image.NRGBA),
+		textures:   make(map[string]*image.NRGBA),
 		models:     make(map[string]*mesh.Model),
 		dummyImage: dummyImage,
+		overrides:  make(map[string][]textureOverride),
+		missing:    make(map[string]struct{}),
+	}
+}
+
+// fetchMediaDirs loads media from all directories in paths. Like with mods in
+// Minetest, files in later directories replace ones with the same name from
+// earlier directories.
+func (m *MediaCache) fetchMediaDirs(paths []string) error {
+	for _, path := range paths {
+		err := m.fetchMedia(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// imageDecoders maps file extensions to functions decoding images in the
+// corresponding format
+var imageDecoders = map[string]func(r io.Reader) (*image.NRGBA, error){
+	".png":  raster.DecodePNG,
+	".jpg":  raster.DecodeJPEG,
+	".jpeg": raster.DecodeJPEG,
+	".tga":  raster.DecodeTGA,
+}
+
+// mediaKey normalizes media file names. Minetest resolves them
+// case-insensitively on some platforms and mods rely on that.
+func mediaKey(name string) string {
+	return strings.ToLower(name)
+}
+
+// mediaExt returns the extension of the file at path in lower case, since
+// file names are matched case-insensitively
+func mediaExt(path string) string {
+	return strings.ToLower(filepath.Ext(path))
+}
+
+// mediaFile is a media file found on disk or inside of a ZIP archive. path is
+// used to identify the file in the cache and in error messages.
+type mediaFile struct {
+	path string
+	open func() (io.ReadCloser, error)
+}
+
+type decodedMedia struct {
+	image *image.NRGBA
+	model *mesh.Model
+	err   error
+}
+
+// decode reads and decodes the file. Files that fail to decode are reported
+// through decodedMedia.err.
+func (f mediaFile) decode() decodedMedia {
+	r, err := f.open()
+	if err != nil {
+		return decodedMedia{err: err}
+	}
+	defer r.Close()
+
+	ext := mediaExt(f.path)
+
+	if decodeImage, ok := imageDecoders[ext]; ok {
+		img, err := decodeImage(r)
+		return decodedMedia{image: img, err: err}
 	}
+
+	if ext == ".obj" {
+		logging.Debugf("loading model %v", f.path)
+		model, err := mesh.DecodeOBJ(r)
+		if err != nil {
+			return decodedMedia{err: err}
+		}
+		return decodedMedia{model: &model}
+	}
+
+	return decodedMedia{}
 }
 
-func (m *MediaCache) fetchGameAndMedia(gamepath string, path string) error {
-        m.fetchMedia(path)
-        m.fetchMedia(gamepath)
-        return nil
+// decodeMediaFiles decodes files in parallel. Results are returned in the
+// same order as files.
+func decodeMediaFiles(files []mediaFile) []decodedMedia {
+	results := make([]decodedMedia, len(files))
+
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indices {
+				results[index] = files[index].decode()
+			}
+		}()
+	}
+
+	for i := range files {
+		indices <- i
+	}
+	close(indices)
+
+	wg.Wait()
+
+	return results
+}
+
+// isMediaFile reports whether the file at path can be decoded as media
+func isMediaFile(path string) bool {
+	ext := mediaExt(path)
+	_, isImage := imageDecoders[ext]
+	return isImage || ext == ".obj"
+}
+
+// isMediaOrOverrideFile reports whether fetchMedia needs the file at path
+func isMediaOrOverrideFile(path string) bool {
+	return isMediaFile(path) || filepath.Base(path) == textureOverrideFile
 }
 
 func (m *MediaCache) fetchMedia(path string) error {
-	return filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+	var files []mediaFile
+
+	var archives []*zip.ReadCloser
+	defer func() {
+		for _, archive := range archives {
+			archive.Close()
+		}
+	}()
+
+	// Only collect the files while walking so that they can be decoded in
+	// parallel afterwards
+	err := filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
 		if !d.Type().IsRegular() {
 			return nil
 		}
 
-		basePath := filepath.Base(path)
-		switch filepath.Ext(path) {
-		case ".png":
-			img, _ := raster.LoadPNG(path)
-			m.images[basePath] = img
-		case ".obj":
-			log.Println(path)
-			model, err := mesh.LoadOBJ(path)
+		if mediaExt(path) == ".zip" {
+			archive, err := zip.OpenReader(path)
 			if err != nil {
-				return err
+				logging.Warnf("failed to load %v: %v", path, err)
+				return nil
 			}
-			m.models[basePath] = &model
+			archives = append(archives, archive)
+			files = append(files, zipMediaFiles(path, archive)...)
+			return nil
 		}
 
+		if !isMediaOrOverrideFile(path) {
+			return nil
+		}
+
+		files = append(files, mediaFile{
+			path: path,
+			open: func() (io.ReadCloser, error) {
+				return os.Open(path)
+			},
+		})
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	var mediaFiles []mediaFile
+	for _, file := range files {
+		if filepath.Base(file.path) == textureOverrideFile {
+			m.loadTextureOverrides(file)
+			continue
+		}
+
+		mediaFiles = append(mediaFiles, file)
+	}
+
+	// Files are added in the order they were found, so that if several files
+	// have the same name, the last one wins like before
+	for i, media := range decodeMediaFiles(mediaFiles) {
+		file := mediaFiles[i]
+		key := mediaKey(filepath.Base(file.path))
+
+		if media.err != nil {
+			if mediaExt(file.path) == ".obj" {
+				return media.err
+			}
+
+			// A single broken texture shouldn't prevent loading the rest
+			logging.Warnf("failed to load %v: %v", file.path, media.err)
+			continue
+		}
+
+		m.mutex.Lock()
+		_, imageExists := m.images[key]
+		_, modelExists := m.models[key]
+		if media.image != nil {
+			m.images[key] = media.image
+		}
+		if media.model != nil {
+			m.models[key] = media.model
+		}
+		m.mutex.Unlock()
+
+		if imageExists || modelExists {
+			logging.Debugf("%v overrides previously loaded %v", file.path, key)
+		}
+	}
+
+	return nil
 }
 
+// zipMediaFiles lists media and texture override files stored anywhere inside a ZIP archive, such
+// as a mod or game downloaded from ContentDB
+func zipMediaFiles(archivePath string, archive *zip.ReadCloser) []mediaFile {
+	var files []mediaFile
+
+	for _, entry := range archive.File {
+		if entry.FileInfo().IsDir() || !isMediaOrOverrideFile(entry.Name) {
+			continue
+		}
+
+		files = append(files, mediaFile{
+			path: filepath.Join(archivePath, entry.Name),
+			open: entry.Open,
+		})
+	}
+
+	return files
+}
+
+// Image returns the image loaded from file called name. Texture expressions
+// with modifiers are handled by ResolveTexture.
 func (m *MediaCache) Image(name string) *image.NRGBA {
-	// FIXME: resolve modifiers
-	baseName := strings.Split(name, "^")[0]
+	m.mutex.RLock()
+	img, ok := m.images[mediaKey(name)]
+	m.mutex.RUnlock()
 
-	if img, ok := m.images[baseName]; ok {
+	if ok {
 		return img
 	} else {
-		log.Printf("unknown image: %v\n", name)
+		m.reportMissing("image", name)
 		return m.dummyImage
 	}
 }
 
+// Palette returns colors of the palette image called name in row-major order,
+// or nil if the image doesn't exist
+func (m *MediaCache) Palette(name string) []color.NRGBA {
+	m.mutex.RLock()
+	img, ok := m.images[mediaKey(name)]
+	m.mutex.RUnlock()
+
+	if !ok {
+		m.reportMissing("palette", name)
+		return nil
+	}
+
+	bounds := img.Bounds()
+	palette := make([]color.NRGBA, 0, bounds.Dx()*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			palette = append(palette, img.NRGBAAt(x, y))
+		}
+	}
+
+	return palette
+}
+
 func (m *MediaCache) Mesh(name string) *mesh.Model {
-	if model, ok := m.models[name]; ok {
+	m.mutex.RLock()
+	model, ok := m.models[mediaKey(name)]
+	m.mutex.RUnlock()
+
+	if ok {
 		return model
 	} else {
-		log.Printf("unknown model: %v\n", name)
+		m.reportMissing("model", name)
 		return nil
 	}
 }
+
+// reportMissing records a media file that couldn't be found. Each name is
+// logged only once.
+func (m *MediaCache) reportMissing(kind, name string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.missing[name]; ok {
+		return
+	}
+
+	m.missing[name] = struct{}{}
+	logging.Warnf("unknown %v: %v", kind, name)
+}
+
+// MissingMedia returns a sorted list of media files that were requested but
+// not found
+func (m *MediaCache) MissingMedia() []string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	names := make([]string, 0, len(m.missing))
+	for name := range m.missing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
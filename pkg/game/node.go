@@ -3,6 +3,7 @@ package game
 import (
 	"encoding/json"
 	"fmt"
+	"image/color"
 )
 
 type DrawType int
@@ -51,6 +52,10 @@ func (t DrawType) IsLiquid() bool {
 	return t == DrawTypeLiquid || t == DrawTypeFlowingLiquid
 }
 
+func (t DrawType) IsGlasslike() bool {
+	return t == DrawTypeGlasslike || t == DrawTypeGlasslikeFramed
+}
+
 func (t *DrawType) UnmarshalJSON(data []byte) error {
 	var name string
 	err := json.Unmarshal(data, &name)
@@ -145,6 +150,83 @@ func (t *ParamType2) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// AlphaMode corresponds to use_texture_alpha
+type AlphaMode int
+
+const (
+	// AlphaModeDefault is used when use_texture_alpha isn't specified. The
+	// actual behavior depends on drawtype.
+	AlphaModeDefault AlphaMode = iota
+	AlphaModeOpaque
+	AlphaModeClip
+	AlphaModeBlend
+)
+
+var AlphaModeNames = map[string]AlphaMode{
+	"opaque": AlphaModeOpaque,
+	"clip":   AlphaModeClip,
+	"blend":  AlphaModeBlend,
+}
+
+func (t *AlphaMode) UnmarshalJSON(data []byte) error {
+	// Before Minetest 5.4, use_texture_alpha was a boolean
+	var legacy bool
+	if err := json.Unmarshal(data, &legacy); err == nil {
+		if legacy {
+			*t = AlphaModeBlend
+		} else {
+			*t = AlphaModeDefault
+		}
+		return nil
+	}
+
+	var name string
+	err := json.Unmarshal(data, &name)
+	if err != nil {
+		return err
+	}
+
+	if alphaMode, ok := AlphaModeNames[name]; ok {
+		*t = alphaMode
+	} else {
+		return fmt.Errorf("invalid use_texture_alpha: `%s`", name)
+	}
+
+	return nil
+}
+
+// NodeColor corresponds to the color field of node definition, which is used
+// to tint node textures
+type NodeColor color.NRGBA
+
+func (c *NodeColor) UnmarshalJSON(data []byte) error {
+	var colorString string
+	if err := json.Unmarshal(data, &colorString); err == nil {
+		parsed, err := ParseColor(colorString)
+		if err != nil {
+			return err
+		}
+		*c = NodeColor(parsed)
+		return nil
+	}
+
+	// Colors may also be dumped as ColorSpec tables
+	type colorSpec struct {
+		R uint8 `json:"r"`
+		G uint8 `json:"g"`
+		B uint8 `json:"b"`
+		A uint8 `json:"a"`
+	}
+	spec := colorSpec{A: 255}
+
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return err
+	}
+
+	*c = NodeColor{R: spec.R, G: spec.G, B: spec.B, A: spec.A}
+	return nil
+}
+
 type NodeBox struct {
 	Type  string
 	Fixed [][]float64
@@ -162,7 +244,9 @@ func (n *NodeBox) UnmarshalJSON(data []byte) error {
 
 	n.Type = inner.Type
 	n.Fixed = make([][]float64, 0)
-	if inner.Type != "fixed" {
+
+	// Leveled node boxes are fixed boxes with height depending on param2
+	if inner.Type != "fixed" && inner.Type != "leveled" {
 		return nil
 	}
 
@@ -170,46 +254,98 @@ func (n *NodeBox) UnmarshalJSON(data []byte) error {
 		return nil
 	}
 
-	if _, ok := inner.Fixed[0].(float64); ok {
+	parseBox := func(values []interface{}) error {
+		if len(values) < 6 {
+			return fmt.Errorf("invalid node box: `%v`", values)
+		}
+
 		box := make([]float64, 0)
 		for i := 0; i < 6; i++ {
-			v, _ := inner.Fixed[i].(float64)
+			v, _ := values[i].(float64)
 			box = append(box, v)
 		}
 		n.Fixed = append(n.Fixed, box)
+
+		return nil
+	}
+
+	if _, ok := inner.Fixed[0].(float64); ok {
+		return parseBox(inner.Fixed)
 	}
 
-	if _, ok := inner.Fixed[0].([]interface{}); ok {
-		for _, boxInterface := range inner.Fixed {
-			boxFloat64 := boxInterface.([]interface{})
-			box := make([]float64, 0)
-			for i := 0; i < 6; i++ {
-				v, _ := boxFloat64[i].(float64)
-				box = append(box, v)
-			}
-			n.Fixed = append(n.Fixed, box)
+	for _, boxInterface := range inner.Fixed {
+		values, ok := boxInterface.([]interface{})
+		if !ok {
+			return fmt.Errorf("invalid node box: `%v`", boxInterface)
+		}
+
+		if err := parseBox(values); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
+// TileAnimation describes a `vertical_frames` animation, where frames are
+// stacked on top of each other and each one has the given aspect ratio
+type TileAnimation struct {
+	AspectW int `json:"aspect_w"`
+	AspectH int `json:"aspect_h"`
+}
+
+type TileDescriptor struct {
+	Name      string         `json:"name"`
+	Animation *TileAnimation `json:"animation"`
+}
+
+func (t *TileDescriptor) UnmarshalJSON(data []byte) error {
+	// Tiles are usually specified as plain texture names
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*t = TileDescriptor{Name: name}
+		return nil
+	}
+
+	type tileDescriptor TileDescriptor
+	inner := &tileDescriptor{}
+
+	if err := json.Unmarshal(data, inner); err != nil {
+		return err
+	}
+
+	*t = TileDescriptor(*inner)
+
+	return nil
+}
+
 type NodeDescriptor struct {
-	DrawType   DrawType   `json:"drawtype"`
-	ParamType  ParamType  `json:"paramtype"`
-	ParamType2 ParamType2 `json:"paramtype2"`
-	Tiles      []string   `json:"tiles"`
-	NodeBox    *NodeBox   `json:"node_box"`
-	Mesh       *string    `json:"mesh"`
+	DrawType        DrawType         `json:"drawtype"`
+	ParamType       ParamType        `json:"paramtype"`
+	ParamType2      ParamType2       `json:"paramtype2"`
+	Tiles           []TileDescriptor `json:"tiles"`
+	NodeBox         *NodeBox         `json:"node_box"`
+	Mesh            *string          `json:"mesh"`
+	UseTextureAlpha AlphaMode        `json:"use_texture_alpha"`
+	Color           *NodeColor       `json:"color"`
+	// Palette names the image used to color the node when ParamType2 is one
+	// of the color types
+	Palette *string `json:"palette"`
+	// VisualScale resizes plantlike and mesh nodes
+	VisualScale float64 `json:"visual_scale"`
+	// Leveled is the level of leveled node boxes used when param2 doesn't
+	// specify it
+	Leveled int `json:"leveled"`
 }
 
 func (n *NodeDescriptor) UnmarshalJSON(data []byte) error {
 	type nodeDescriptor NodeDescriptor
 	inner := &nodeDescriptor{
-		DrawType:   DrawTypeNormal,
-		Tiles:      []string{},
-		ParamType:  ParamTypeLight,
-		ParamType2: ParamType2None,
+		DrawType:    DrawTypeNormal,
+		Tiles:       []TileDescriptor{},
+		ParamType:   ParamTypeLight,
+		ParamType2:  ParamType2None,
+		VisualScale: 1,
 	}
 
 	if err := json.Unmarshal(data, inner); err != nil {
@@ -0,0 +1,132 @@
+package game
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/weqqr/panorama/pkg/logging"
+)
+
+const textureOverrideFile = "texture_override.txt"
+
+// overrideTargets maps texture_override.txt targets to indices of tiles they
+// replace. Targets that don't affect rendered nodes, such as `inventory`,
+// aren't listed.
+var overrideTargets = map[string][]int{
+	"top":    {0},
+	"bottom": {1},
+	"right":  {2},
+	"left":   {3},
+	"back":   {4},
+	"front":  {5},
+	"sides":  {2, 3, 4, 5},
+	"all":    {0, 1, 2, 3, 4, 5},
+}
+
+// textureOverride replaces tiles of a single node
+type textureOverride struct {
+	tiles   []int
+	texture string
+}
+
+// parseTextureOverrides parses lines formatted as `node_name targets texture`,
+// where targets is a comma-separated list of faces
+func parseTextureOverrides(r io.Reader) (map[string][]textureOverride, error) {
+	overrides := make(map[string][]textureOverride)
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+
+		// Skip comments and empty lines
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %v: expected 3 fields, got %v", lineNumber, len(fields))
+		}
+
+		override := textureOverride{
+			texture: fields[2],
+		}
+		for _, target := range strings.Split(fields[1], ",") {
+			override.tiles = append(override.tiles, overrideTargets[target]...)
+		}
+
+		if len(override.tiles) > 0 {
+			overrides[fields[0]] = append(overrides[fields[0]], override)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return overrides, nil
+}
+
+// loadTextureOverrides adds overrides from a texture_override.txt file. They
+// are applied after the ones loaded previously.
+func (m *MediaCache) loadTextureOverrides(file mediaFile) {
+	r, err := file.open()
+	if err != nil {
+		logging.Warnf("failed to load %v: %v", file.path, err)
+		return
+	}
+	defer r.Close()
+
+	overrides, err := parseTextureOverrides(r)
+	if err != nil {
+		logging.Warnf("failed to load %v: %v", file.path, err)
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for node, nodeOverrides := range overrides {
+		m.overrides[node] = append(m.overrides[node], nodeOverrides...)
+	}
+}
+
+// OverrideTiles applies texture overrides defined for node to its tiles.
+// tiles is returned unchanged if there are no overrides for the node.
+func (m *MediaCache) OverrideTiles(node string, tiles []TileDescriptor) []TileDescriptor {
+	m.mutex.RLock()
+	overrides := m.overrides[node]
+	m.mutex.RUnlock()
+
+	if len(overrides) == 0 {
+		return tiles
+	}
+
+	// Expand tiles to all 6 faces the same way as missing tiles are filled when
+	// making node definitions, so that a single face can be replaced
+	count := 6
+	if len(tiles) > count {
+		count = len(tiles)
+	}
+
+	overridden := make([]TileDescriptor, count)
+	for i := range overridden {
+		if i < len(tiles) {
+			overridden[i] = tiles[i]
+		} else if len(tiles) > 0 {
+			overridden[i] = tiles[len(tiles)-1]
+		}
+	}
+
+	for _, override := range overrides {
+		for _, i := range override.tiles {
+			overridden[i] = TileDescriptor{Name: override.texture}
+		}
+	}
+
+	return overridden
+}
@@ -0,0 +1,595 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+	"strings"
+
+	"github.com/weqqr/panorama/pkg/logging"
+)
+
+// textureModifier transforms base according to args. base is nil if the
+// modifier starts the texture expression, e.g. `[combine`. Implementations
+// must not modify base, as it may be shared with the cache.
+type textureModifier func(m *MediaCache, base *image.NRGBA, args string) (*image.NRGBA, error)
+
+// textureModifiers maps modifier names (without the leading `[`) to their
+// implementations
+var textureModifiers map[string]textureModifier
+
+func init() {
+	// Modifiers may resolve nested textures, so the map has to be filled in
+	// after initialization to avoid an initialization cycle
+	textureModifiers = map[string]textureModifier{
+		"combine":   combineModifier,
+		"colorize":  colorizeModifier,
+		"crop":      cropModifier,
+		"opacity":   opacityModifier,
+		"resize":    resizeModifier,
+		"sheet":     sheetModifier,
+		"transform": transformModifier,
+	}
+}
+
+// splitUnescaped splits s on every occurrence of sep that isn't escaped with a
+// backslash or enclosed in parentheses
+func splitUnescaped(s string, sep byte) []string {
+	var parts []string
+
+	depth := 0
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case sep:
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+// unescape removes backslashes used to escape special characters
+func unescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// maxTextureSize limits the width and height of textures created by
+// modifiers. Sizes come from texture strings of mods, so a typo mustn't be
+// able to allocate gigabytes.
+const maxTextureSize = 4096
+
+func parseSize(s string) (int, int, error) {
+	parts := strings.SplitN(s, "x", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid size: `%v`", s)
+	}
+
+	width, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid size: `%v`", s)
+	}
+
+	height, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid size: `%v`", s)
+	}
+
+	if width <= 0 || height <= 0 {
+		return 0, 0, fmt.Errorf("invalid size: `%v`", s)
+	}
+
+	if width > maxTextureSize || height > maxTextureSize {
+		return 0, 0, fmt.Errorf("size exceeds %vx%v: `%v`", maxTextureSize, maxTextureSize, s)
+	}
+
+	return width, height, nil
+}
+
+func parseInts(s string, count int) ([]int, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != count {
+		return nil, fmt.Errorf("expected %v comma-separated numbers, got `%v`", count, s)
+	}
+
+	values := make([]int, count)
+	for i, part := range parts {
+		value, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+
+	return values, nil
+}
+
+// scaleNearest resizes img using nearest-neighbor interpolation, which keeps
+// pixel art crisp
+func scaleNearest(img *image.NRGBA, width, height int) *image.NRGBA {
+	src := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			srcX := src.Min.X + x*src.Dx()/width
+			srcY := src.Min.Y + y*src.Dy()/height
+			dst.SetNRGBA(x, y, img.NRGBAAt(srcX, srcY))
+		}
+	}
+
+	return dst
+}
+
+// overlay draws top over base. If the images have different sizes, the
+// smaller one is upscaled to match the larger one like in Minetest.
+func overlay(base, top *image.NRGBA) *image.NRGBA {
+	width, height := base.Bounds().Dx(), base.Bounds().Dy()
+	if top.Bounds().Dx() > width {
+		width = top.Bounds().Dx()
+	}
+	if top.Bounds().Dy() > height {
+		height = top.Bounds().Dy()
+	}
+
+	rect := image.Rect(0, 0, width, height)
+	dst := image.NewNRGBA(rect)
+	draw.Draw(dst, rect, scaleNearest(base, width, height), image.Point{}, draw.Src)
+	draw.Draw(dst, rect, scaleNearest(top, width, height), image.Point{}, draw.Over)
+
+	return dst
+}
+
+// `[combine:WxH:X,Y=texture:X,Y=texture...` creates a WxH image and draws
+// textures onto it at given offsets
+func combineModifier(m *MediaCache, base *image.NRGBA, args string) (*image.NRGBA, error) {
+	parts := splitUnescaped(args, ':')
+
+	width, height, err := parseSize(parts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for _, part := range parts[1:] {
+		entry := strings.SplitN(part, "=", 2)
+		if len(entry) != 2 {
+			return nil, fmt.Errorf("invalid combine entry: `%v`", part)
+		}
+
+		offset, err := parseInts(entry[0], 2)
+		if err != nil {
+			return nil, fmt.Errorf("invalid combine entry: `%v`: %w", part, err)
+		}
+
+		img, err := m.resolveTexture(unescape(entry[1]))
+		if err != nil {
+			return nil, err
+		}
+
+		origin := image.Pt(offset[0], offset[1])
+		draw.Draw(dst, img.Bounds().Add(origin), img, img.Bounds().Min, draw.Over)
+	}
+
+	return dst, nil
+}
+
+func crop(base *image.NRGBA, x, y, width, height int) (*image.NRGBA, error) {
+	rect := image.Rect(x, y, x+width, y+height).Add(base.Bounds().Min)
+	if width <= 0 || height <= 0 || !rect.In(base.Bounds()) {
+		return nil, fmt.Errorf("crop rectangle %v is outside of %v", rect, base.Bounds())
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), base, rect.Min, draw.Src)
+
+	return dst, nil
+}
+
+// `[crop:X,Y,W,H` cuts out the WxH rectangle starting at X,Y
+func cropModifier(m *MediaCache, base *image.NRGBA, args string) (*image.NRGBA, error) {
+	if base == nil {
+		return nil, errors.New("nothing to crop")
+	}
+
+	values, err := parseInts(args, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	return crop(base, values[0], values[1], values[2], values[3])
+}
+
+// `[sheet:WxH:X,Y` divides the texture into a WxH grid of tiles and selects
+// the tile at X,Y
+func sheetModifier(m *MediaCache, base *image.NRGBA, args string) (*image.NRGBA, error) {
+	if base == nil {
+		return nil, errors.New("no sheet to select a tile from")
+	}
+
+	parts := strings.SplitN(args, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid sheet arguments: `%v`", args)
+	}
+
+	columns, rows, err := parseSize(parts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	tile, err := parseInts(parts[1], 2)
+	if err != nil {
+		return nil, err
+	}
+
+	width := base.Bounds().Dx() / columns
+	height := base.Bounds().Dy() / rows
+
+	return crop(base, tile[0]*width, tile[1]*height, width, height)
+}
+
+// ParseColor parses hexadecimal color strings in `#RGB`, `#RGBA`, `#RRGGBB`
+// and `#RRGGBBAA` formats. Named colors aren't supported.
+func ParseColor(s string) (color.NRGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == len(s) {
+		return color.NRGBA{}, fmt.Errorf("unsupported color: `%v`", s)
+	}
+
+	// Expand the short forms so that every component takes two digits
+	if len(hex) == 3 || len(hex) == 4 {
+		var expanded strings.Builder
+		for i := 0; i < len(hex); i++ {
+			expanded.WriteByte(hex[i])
+			expanded.WriteByte(hex[i])
+		}
+		hex = expanded.String()
+	}
+
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+
+	if len(hex) != 8 {
+		return color.NRGBA{}, fmt.Errorf("unsupported color: `%v`", s)
+	}
+
+	value, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("unsupported color: `%v`", s)
+	}
+
+	return color.NRGBA{
+		R: uint8(value >> 24),
+		G: uint8(value >> 16),
+		B: uint8(value >> 8),
+		A: uint8(value),
+	}, nil
+}
+
+// `[colorize:COLOR:RATIO` blends the texture with COLOR. RATIO ranges from 0
+// (texture color only) to 255 (COLOR only) and defaults to alpha of COLOR. If
+// RATIO is `alpha`, pixels take the color of COLOR with alpha multiplied by
+// texture alpha.
+func colorizeModifier(m *MediaCache, base *image.NRGBA, args string) (*image.NRGBA, error) {
+	if base == nil {
+		return nil, errors.New("nothing to colorize")
+	}
+
+	parts := strings.SplitN(args, ":", 2)
+
+	tint, err := ParseColor(parts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	useAlpha := false
+	ratio := int(tint.A)
+	if len(parts) == 2 {
+		if parts[1] == "alpha" {
+			useAlpha = true
+		} else {
+			ratio, err = strconv.Atoi(parts[1])
+			if err != nil || ratio < 0 || ratio > 255 {
+				return nil, fmt.Errorf("invalid ratio: `%v`", parts[1])
+			}
+		}
+	}
+
+	lerp := func(a, b uint8) uint8 {
+		return uint8((int(a)*(255-ratio) + int(b)*ratio + 127) / 255)
+	}
+
+	bounds := base.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			c := base.NRGBAAt(bounds.Min.X+x, bounds.Min.Y+y)
+
+			if useAlpha {
+				c.R, c.G, c.B = tint.R, tint.G, tint.B
+				c.A = uint8((int(c.A)*int(tint.A) + 127) / 255)
+			} else {
+				c.R, c.G, c.B = lerp(c.R, tint.R), lerp(c.G, tint.G), lerp(c.B, tint.B)
+			}
+
+			dst.SetNRGBA(x, y, c)
+		}
+	}
+
+	return dst, nil
+}
+
+// `[resize:WxH` scales the texture to WxH using nearest-neighbor
+// interpolation
+func resizeModifier(m *MediaCache, base *image.NRGBA, args string) (*image.NRGBA, error) {
+	if base == nil {
+		return nil, errors.New("nothing to resize")
+	}
+
+	width, height, err := parseSize(args)
+	if err != nil {
+		return nil, err
+	}
+
+	return scaleNearest(base, width, height), nil
+}
+
+// `[opacity:N` multiplies alpha of every pixel by N/255
+func opacityModifier(m *MediaCache, base *image.NRGBA, args string) (*image.NRGBA, error) {
+	if base == nil {
+		return nil, errors.New("nothing to change opacity of")
+	}
+
+	opacity, err := strconv.Atoi(args)
+	if err != nil || opacity < 0 || opacity > 255 {
+		return nil, fmt.Errorf("invalid opacity: `%v`", args)
+	}
+
+	bounds := base.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			c := base.NRGBAAt(bounds.Min.X+x, bounds.Min.Y+y)
+			c.A = uint8((int(c.A)*opacity + 127) / 255)
+			dst.SetNRGBA(x, y, c)
+		}
+	}
+
+	return dst, nil
+}
+
+// rotate90 rotates img by 90 degrees counter-clockwise
+func rotate90(img *image.NRGBA) *image.NRGBA {
+	bounds := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, bounds.Dy(), bounds.Dx()))
+
+	for y := 0; y < bounds.Dx(); y++ {
+		for x := 0; x < bounds.Dy(); x++ {
+			c := img.NRGBAAt(bounds.Max.X-1-y, bounds.Min.Y+x)
+			dst.SetNRGBA(x, y, c)
+		}
+	}
+
+	return dst
+}
+
+// flip mirrors img horizontally (along the X axis) or vertically
+func flip(img *image.NRGBA, horizontal bool) *image.NRGBA {
+	bounds := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			srcX, srcY := bounds.Min.X+x, bounds.Min.Y+y
+			if horizontal {
+				srcX = bounds.Max.X - 1 - x
+			} else {
+				srcY = bounds.Max.Y - 1 - y
+			}
+			dst.SetNRGBA(x, y, img.NRGBAAt(srcX, srcY))
+		}
+	}
+
+	return dst
+}
+
+// imageTransforms lists transforms accepted by `[transform` in order of
+// their numeric codes
+var imageTransforms = []struct {
+	name  string
+	apply func(img *image.NRGBA) *image.NRGBA
+}{
+	{"I", func(img *image.NRGBA) *image.NRGBA { return img }},
+	{"R90", rotate90},
+	{"R180", func(img *image.NRGBA) *image.NRGBA { return rotate90(rotate90(img)) }},
+	{"R270", func(img *image.NRGBA) *image.NRGBA { return rotate90(rotate90(rotate90(img))) }},
+	{"FX", func(img *image.NRGBA) *image.NRGBA { return flip(img, true) }},
+	{"FXR90", func(img *image.NRGBA) *image.NRGBA { return rotate90(flip(img, true)) }},
+	{"FY", func(img *image.NRGBA) *image.NRGBA { return flip(img, false) }},
+	{"FYR90", func(img *image.NRGBA) *image.NRGBA { return rotate90(flip(img, false)) }},
+}
+
+// `[transformT` rotates (counter-clockwise) and flips the texture. T is a
+// sequence of transforms applied left to right, given either by name (`R90`,
+// `FX`, ...) or by numeric code (`1`, `4`, ...).
+func transformModifier(m *MediaCache, base *image.NRGBA, args string) (*image.NRGBA, error) {
+	if base == nil {
+		return nil, errors.New("nothing to transform")
+	}
+
+	result := base
+	for rest := args; rest != ""; {
+		if code := int(rest[0] - '0'); 0 <= code && code < len(imageTransforms) {
+			result = imageTransforms[code].apply(result)
+			rest = rest[1:]
+			continue
+		}
+
+		// FX is a prefix of FXR90, but that's fine since FX followed by R90
+		// is the same transform
+		matched := false
+		for _, transform := range imageTransforms {
+			if strings.HasPrefix(rest, transform.name) {
+				result = transform.apply(result)
+				rest = rest[len(transform.name):]
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return nil, fmt.Errorf("unknown transform: `%v`", rest)
+		}
+	}
+
+	return result, nil
+}
+
+// applyModifier applies a single `[name:args` modifier to base
+func (m *MediaCache) applyModifier(base *image.NRGBA, spec string) (*image.NRGBA, error) {
+	spec = strings.TrimPrefix(spec, "[")
+
+	nameLength := 0
+	for nameLength < len(spec) && 'a' <= spec[nameLength] && spec[nameLength] <= 'z' {
+		nameLength++
+	}
+
+	name := spec[:nameLength]
+	args := strings.TrimPrefix(spec[nameLength:], ":")
+
+	modifier, ok := textureModifiers[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported texture modifier: `%v`", name)
+	}
+
+	img, err := modifier(m, base, args)
+	if err != nil {
+		return nil, fmt.Errorf("applying `[%v`: %w", name, err)
+	}
+
+	return img, nil
+}
+
+// resolveTexture evaluates a texture expression consisting of file names,
+// parenthesized subexpressions and modifiers joined by `^`
+func (m *MediaCache) resolveTexture(spec string) (*image.NRGBA, error) {
+	var result *image.NRGBA
+
+	for _, part := range splitUnescaped(spec, '^') {
+		if strings.HasPrefix(part, "[") {
+			img, err := m.applyModifier(result, part)
+			if err != nil {
+				return nil, err
+			}
+			result = img
+			continue
+		}
+
+		var img *image.NRGBA
+		if strings.HasPrefix(part, "(") && strings.HasSuffix(part, ")") {
+			var err error
+			img, err = m.resolveTexture(part[1 : len(part)-1])
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			img = m.Image(part)
+		}
+
+		if result == nil {
+			result = img
+		} else {
+			result = overlay(result, img)
+		}
+	}
+
+	if result == nil {
+		return nil, fmt.Errorf("empty texture: `%v`", spec)
+	}
+
+	return result, nil
+}
+
+// ResolveTexture evaluates a texture expression, such as
+// `default_stone.png^[crop:0,0,8,8^mossy_overlay.png`. Invalid expressions
+// resolve to the dummy image. Results are cached, so the returned image must
+// not be modified.
+func (m *MediaCache) ResolveTexture(spec string) *image.NRGBA {
+	m.mutex.RLock()
+	img, ok := m.textures[spec]
+	m.mutex.RUnlock()
+
+	if ok {
+		return img
+	}
+
+	img, err := m.resolveTexture(spec)
+	if err != nil {
+		logging.Warnf("invalid texture %v: %v", spec, err)
+		img = m.dummyImage
+	}
+
+	m.mutex.Lock()
+	m.textures[spec] = img
+	m.mutex.Unlock()
+
+	return img
+}
+
+// FirstFrame returns the topmost frameHeight pixels of an animated texture.
+// Textures that aren't taller than frameHeight are returned unchanged.
+func FirstFrame(img *image.NRGBA, frameHeight int) *image.NRGBA {
+	bounds := img.Bounds()
+	if frameHeight <= 0 || bounds.Dy() <= frameHeight {
+		return img
+	}
+
+	frame, err := crop(img, 0, 0, bounds.Dx(), frameHeight)
+	if err != nil {
+		return img
+	}
+
+	return frame
+}
+
+// ResolveTile resolves the texture of a node tile. Only the first frame of
+// animated textures is kept, since tiles are rendered statically. If the
+// tile doesn't describe its animation, textures made of square frames stacked
+// vertically are detected as animated.
+func (m *MediaCache) ResolveTile(tile TileDescriptor) *image.NRGBA {
+	img := m.ResolveTexture(tile.Name)
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+
+	if tile.Animation != nil {
+		if tile.Animation.AspectW <= 0 || tile.Animation.AspectH <= 0 {
+			return img
+		}
+		return FirstFrame(img, width*tile.Animation.AspectH/tile.Animation.AspectW)
+	}
+
+	if width > 0 && height > width && height%width == 0 {
+		return FirstFrame(img, width)
+	}
+
+	return img
+}
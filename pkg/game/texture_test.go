@@ -0,0 +1,126 @@
+package game
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// testTexture returns a 2x2 texture with a black, a white, a translucent and
+// a transparent pixel
+func testTexture() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	img.SetNRGBA(0, 0, color.NRGBA{0, 0, 0, 255})
+	img.SetNRGBA(1, 0, color.NRGBA{255, 255, 255, 255})
+	img.SetNRGBA(0, 1, color.NRGBA{100, 50, 200, 128})
+	img.SetNRGBA(1, 1, color.NRGBA{10, 20, 30, 0})
+	return img
+}
+
+func checkPixels(t *testing.T, name string, img *image.NRGBA, want []color.NRGBA) {
+	t.Helper()
+
+	if img.Bounds().Dx()*img.Bounds().Dy() != len(want) {
+		t.Fatalf("%v: got %v, want %v pixels", name, img.Bounds(), len(want))
+	}
+
+	for i, c := range want {
+		x, y := img.Bounds().Min.X+i%img.Bounds().Dx(), img.Bounds().Min.Y+i/img.Bounds().Dx()
+		if got := img.NRGBAAt(x, y); got != c {
+			t.Errorf("%v: pixel at %v, %v is %v, want %v", name, x, y, got, c)
+		}
+	}
+}
+
+func TestColorizeModifier(t *testing.T) {
+	for _, test := range []struct {
+		args string
+		want []color.NRGBA
+	}{
+		{"#FF0000:128", []color.NRGBA{{128, 0, 0, 255}, {255, 127, 127, 255}, {178, 25, 100, 128}, {133, 10, 15, 0}}},
+		// The ratio defaults to alpha of the color
+		{"#0000FF40", []color.NRGBA{{0, 0, 64, 255}, {191, 191, 255, 255}, {75, 37, 214, 128}, {7, 15, 86, 0}}},
+		{"#00FF0080:alpha", []color.NRGBA{{0, 255, 0, 128}, {0, 255, 0, 128}, {0, 255, 0, 64}, {0, 255, 0, 0}}},
+		{"#FF0000:0", []color.NRGBA{{0, 0, 0, 255}, {255, 255, 255, 255}, {100, 50, 200, 128}, {10, 20, 30, 0}}},
+	} {
+		img, err := colorizeModifier(nil, testTexture(), test.args)
+		if err != nil {
+			t.Errorf("%v: %v", test.args, err)
+			continue
+		}
+
+		checkPixels(t, test.args, img, test.want)
+	}
+}
+
+func TestColorizeModifierInvalid(t *testing.T) {
+	for _, args := range []string{"#FF0000:256", "#FF0000:-1", "#FF0000:half", "nocolor"} {
+		if _, err := colorizeModifier(nil, testTexture(), args); err == nil {
+			t.Errorf("%v: no error", args)
+		}
+	}
+}
+
+func TestResizeModifier(t *testing.T) {
+	black, white := color.NRGBA{0, 0, 0, 255}, color.NRGBA{255, 255, 255, 255}
+	translucent, transparent := color.NRGBA{100, 50, 200, 128}, color.NRGBA{10, 20, 30, 0}
+
+	for _, test := range []struct {
+		args string
+		want []color.NRGBA
+	}{
+		{"4x2", []color.NRGBA{
+			black, black, white, white,
+			translucent, translucent, transparent, transparent,
+		}},
+		{"1x1", []color.NRGBA{black}},
+		{"1x3", []color.NRGBA{black, black, translucent}},
+	} {
+		img, err := resizeModifier(nil, testTexture(), test.args)
+		if err != nil {
+			t.Errorf("%v: %v", test.args, err)
+			continue
+		}
+
+		checkPixels(t, test.args, img, test.want)
+	}
+
+	img, err := resizeModifier(nil, testTexture(), "3x5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 3 || img.Bounds().Dy() != 5 {
+		t.Errorf("3x5: got %v", img.Bounds())
+	}
+
+	for _, args := range []string{"0x4", "4", "4x-1", "5000x1"} {
+		if _, err := resizeModifier(nil, testTexture(), args); err == nil {
+			t.Errorf("%v: no error", args)
+		}
+	}
+}
+
+func TestOpacityModifier(t *testing.T) {
+	for _, test := range []struct {
+		args string
+		want []color.NRGBA
+	}{
+		{"100", []color.NRGBA{{0, 0, 0, 100}, {255, 255, 255, 100}, {100, 50, 200, 50}, {10, 20, 30, 0}}},
+		{"255", []color.NRGBA{{0, 0, 0, 255}, {255, 255, 255, 255}, {100, 50, 200, 128}, {10, 20, 30, 0}}},
+		{"0", []color.NRGBA{{0, 0, 0, 0}, {255, 255, 255, 0}, {100, 50, 200, 0}, {10, 20, 30, 0}}},
+	} {
+		img, err := opacityModifier(nil, testTexture(), test.args)
+		if err != nil {
+			t.Errorf("%v: %v", test.args, err)
+			continue
+		}
+
+		checkPixels(t, test.args, img, test.want)
+	}
+
+	for _, args := range []string{"256", "-1", "half"} {
+		if _, err := opacityModifier(nil, testTexture(), args); err == nil {
+			t.Errorf("%v: no error", args)
+		}
+	}
+}
@@ -8,3 +8,9 @@ import "math"
 func FloorDiv(a, b int) int {
 	return int(math.Floor(float64(a) / float64(b)))
 }
+
+// FloorMod returns the remainder of floor division. Unlike the % operator, the
+// result has the same sign as b, so FloorMod(-1, 16) == 15.
+func FloorMod(a, b int) int {
+	return ((a % b) + b) % b
+}
@@ -0,0 +1,27 @@
+package lm
+
+import "testing"
+
+func TestFloorDivMod(t *testing.T) {
+	for _, test := range []struct {
+		a, b     int
+		div, mod int
+	}{
+		{0, 16, 0, 0},
+		{5, 16, 0, 5},
+		{16, 16, 1, 0},
+		{17, 16, 1, 1},
+		{-1, 16, -1, 15},
+		{-15, 16, -1, 1},
+		{-16, 16, -1, 0},
+		{-17, 16, -2, 15},
+		{-32, 16, -2, 0},
+	} {
+		if got := FloorDiv(test.a, test.b); got != test.div {
+			t.Errorf("FloorDiv(%v, %v) = %v, want %v", test.a, test.b, got, test.div)
+		}
+		if got := FloorMod(test.a, test.b); got != test.mod {
+			t.Errorf("FloorMod(%v, %v) = %v, want %v", test.a, test.b, got, test.mod)
+		}
+	}
+}
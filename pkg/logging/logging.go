@@ -0,0 +1,90 @@
+// Package logging provides leveled logging on top of the standard log package.
+// Messages below the configured level are discarded.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+type Level int32
+
+const (
+	// LevelDebug is for details useful when investigating problems, such as
+	// every loaded or saved file
+	LevelDebug Level = iota
+	// LevelInfo is for progress of long operations
+	LevelInfo
+	// LevelWarn is for problems that don't stop the operation, such as
+	// missing or broken media files
+	LevelWarn
+	// LevelError is for failures of a part of the operation, such as a tile
+	// that couldn't be rendered
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level with the given name
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), nil
+		}
+	}
+
+	return LevelInfo, fmt.Errorf("unknown log level: `%v`", name)
+}
+
+var currentLevel = int32(LevelInfo)
+
+// SetLevel discards messages less important than level
+func SetLevel(level Level) {
+	atomic.StoreInt32(&currentLevel, int32(level))
+}
+
+// Enabled reports whether messages of level are logged
+func Enabled(level Level) bool {
+	return int32(level) >= atomic.LoadInt32(&currentLevel)
+}
+
+func output(level Level, format string, args []interface{}) {
+	if !Enabled(level) {
+		return
+	}
+
+	message := strings.ToUpper(level.String()) + " " + fmt.Sprintf(format, args...)
+	log.Output(3, message)
+}
+
+func Debugf(format string, args ...interface{}) {
+	output(LevelDebug, format, args)
+}
+
+func Infof(format string, args ...interface{}) {
+	output(LevelInfo, format, args)
+}
+
+func Warnf(format string, args ...interface{}) {
+	output(LevelWarn, format, args)
+}
+
+func Errorf(format string, args ...interface{}) {
+	output(LevelError, format, args)
+}
+
+// Fatalf logs the message regardless of the level and exits
+func Fatalf(format string, args ...interface{}) {
+	log.Output(2, "FATAL "+fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
@@ -1,6 +1,8 @@
 package mesh
 
 import (
+	"math"
+
 	"github.com/weqqr/panorama/pkg/lm"
 )
 
@@ -40,6 +42,8 @@ const (
 	CubeFaceDown
 	CubeFaceNorth
 	CubeFaceSouth
+
+	CubeFaceAll = CubeFaceEast | CubeFaceWest | CubeFaceTop | CubeFaceDown | CubeFaceNorth | CubeFaceSouth
 )
 
 func Cuboid(x1, y1, z1, x2, y2, z2 float64, hiddenFaces CubeFaces) []Mesh {
@@ -106,14 +110,44 @@ func Cuboid(x1, y1, z1, x2, y2, z2 float64, hiddenFaces CubeFaces) []Mesh {
 	meshes := []Mesh{yp, ym, xp, xm, zp, zm}
 	meshFaces := []CubeFaces{CubeFaceTop, CubeFaceDown, CubeFaceEast, CubeFaceWest, CubeFaceNorth, CubeFaceSouth}
 
-	culledMesh := []Mesh{}
-	for i, mesh := range meshes {
-		if hiddenFaces&meshFaces[i] == 0 {
-			culledMesh = append(culledMesh, mesh)
+	// Hidden faces are left empty instead of being removed, so that meshes
+	// still match textures of the faces they belong to
+	for i := range meshes {
+		if hiddenFaces&meshFaces[i] != 0 {
+			meshes[i] = NewMesh()
 		}
 	}
 
-	return culledMesh
+	return meshes
+}
+
+// CrossedQuads returns two vertical quads crossing diagonally through the
+// center of the node, as drawn for plants. With size of 1 the quads are as
+// wide and as tall as the node; they always stand on the bottom of the node.
+func CrossedQuads(size float64) []Mesh {
+	// Quads are rotated by 45 degrees
+	h := 0.5 * size / math.Sqrt2
+	y1, y2 := -0.5, -0.5+size
+
+	quad := func(x1, z1, x2, z2 float64) Mesh {
+		normal := lm.Vec3(z2-z1, 0, x1-x2).Normalize()
+
+		m := NewMesh()
+		m.Vertices = []Vertex{
+			{Position: lm.Vec3(x1, y1, z1), Texcoord: lm.Vec2(0.0, 1.0), Normal: normal},
+			{Position: lm.Vec3(x1, y2, z1), Texcoord: lm.Vec2(0.0, 0.0), Normal: normal},
+			{Position: lm.Vec3(x2, y2, z2), Texcoord: lm.Vec2(1.0, 0.0), Normal: normal},
+			{Position: lm.Vec3(x1, y1, z1), Texcoord: lm.Vec2(0.0, 1.0), Normal: normal},
+			{Position: lm.Vec3(x2, y1, z2), Texcoord: lm.Vec2(1.0, 1.0), Normal: normal},
+			{Position: lm.Vec3(x2, y2, z2), Texcoord: lm.Vec2(1.0, 0.0), Normal: normal},
+		}
+		return m
+	}
+
+	return []Mesh{
+		quad(-h, -h, h, h),
+		quad(-h, h, h, -h),
+	}
 }
 
 func Cube(hiddenFaces CubeFaces) *Model {
@@ -123,3 +157,31 @@ func Cube(hiddenFaces CubeFaces) *Model {
 
 	return &model
 }
+
+// LiquidCube returns a cube with top corners moved to heights, which are
+// given for corners (-X, -Z), (+X, -Z), (-X, +Z) and (+X, +Z) and range from
+// -0.5 to 0.5.
+func LiquidCube(hiddenFaces CubeFaces, heights [4]float64) *Model {
+	model := Cube(hiddenFaces)
+
+	for i := range model.Meshes {
+		for j := range model.Meshes[i].Vertices {
+			position := &model.Meshes[i].Vertices[j].Position
+			if position.Y < 0.5 {
+				continue
+			}
+
+			corner := 0
+			if position.X > 0 {
+				corner |= 1
+			}
+			if position.Z > 0 {
+				corner |= 2
+			}
+
+			position.Y = heights[corner]
+		}
+	}
+
+	return model
+}
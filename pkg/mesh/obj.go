@@ -3,6 +3,7 @@ package mesh
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
 	"strings"
@@ -97,7 +98,11 @@ type objParser struct {
 	texcoords []lm.Vector2
 	normals   []lm.Vector3
 
-	mesh Mesh
+	// Faces are grouped into meshes by material, in the order materials first
+	// appear. Each mesh gets its own tile.
+	meshes    []Mesh
+	materials map[string]int
+	current   int
 }
 
 func (o *objParser) vertexAt(triplet Triplet) Vertex {
@@ -145,12 +150,15 @@ func (o *objParser) processLine(line string) error {
 	}
 
 	switch fields[0] {
+	// Like Minetest, X is mirrored to convert to left-handed coordinates and
+	// texture coordinates start at the top of the image
 	case "v":
 		position, err := parseVector3(fields[1:])
 		if err != nil {
 			return err
 		}
 
+		position.X = -position.X
 		o.positions = append(o.positions, position)
 	case "vt":
 		texcoord, err := parseVector2(fields[1:])
@@ -158,6 +166,7 @@ func (o *objParser) processLine(line string) error {
 			return err
 		}
 
+		texcoord.Y = 1 - texcoord.Y
 		o.texcoords = append(o.texcoords, texcoord)
 	case "vn":
 		normal, err := parseVector3(fields[1:])
@@ -165,7 +174,23 @@ func (o *objParser) processLine(line string) error {
 			return err
 		}
 
+		normal.X = -normal.X
 		o.normals = append(o.normals, normal)
+	case "usemtl":
+		name := strings.Join(fields[1:], " ")
+
+		index, ok := o.materials[name]
+		if !ok {
+			// Faces before the first material stay in the first mesh
+			if len(o.materials) > 0 || len(o.meshes[0].Vertices) > 0 {
+				o.meshes = append(o.meshes, NewMesh())
+			}
+
+			index = len(o.meshes) - 1
+			o.materials[name] = index
+		}
+
+		o.current = index
 	case "f":
 		triplets, err := parseFace(fields[1:])
 		if err != nil {
@@ -174,7 +199,7 @@ func (o *objParser) processLine(line string) error {
 
 		vertices := o.triangulatePolygon(triplets)
 
-		o.mesh.Vertices = append(o.mesh.Vertices, vertices...)
+		o.meshes[o.current].Vertices = append(o.meshes[o.current].Vertices, vertices...)
 
 	default:
 		// log.Printf("unknown attribute %v; ignoring\n", fields[0])
@@ -191,12 +216,17 @@ func LoadOBJ(path string) (Model, error) {
 
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
+	return DecodeOBJ(file)
+}
+
+func DecodeOBJ(r io.Reader) (Model, error) {
+	scanner := bufio.NewScanner(r)
 	parser := objParser{
 		positions: []lm.Vector3{},
 		texcoords: []lm.Vector2{},
 		normals:   []lm.Vector3{},
-		mesh:      NewMesh(),
+		meshes:    []Mesh{NewMesh()},
+		materials: make(map[string]int),
 	}
 
 	lineNumber := 1
@@ -213,7 +243,7 @@ func LoadOBJ(path string) (Model, error) {
 	}
 
 	model := NewModel()
-	model.Meshes = append(model.Meshes, parser.mesh)
+	model.Meshes = append(model.Meshes, parser.meshes...)
 
 	return model, nil
 }
@@ -11,14 +11,19 @@ type Depth struct {
 }
 
 func NewDepth(rect image.Rectangle) *Depth {
-	pix := make([]float64, rect.Dx()*rect.Dy())
-	for i := range pix {
-		pix[i] = math.MaxFloat64
+	depth := &Depth{
+		Pix:  make([]float64, rect.Dx()*rect.Dy()),
+		Rect: rect,
 	}
+	depth.Clear()
 
-	return &Depth{
-		Pix:  pix,
-		Rect: rect,
+	return depth
+}
+
+// Clear moves all pixels to the maximum depth
+func (d *Depth) Clear() {
+	for i := range d.Pix {
+		d.Pix[i] = math.MaxFloat64
 	}
 }
 
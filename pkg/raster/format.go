@@ -0,0 +1,116 @@
+package raster
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ImageFormat is a file format of saved images
+type ImageFormat int
+
+const (
+	ImageFormatPNG ImageFormat = iota
+	ImageFormatJPEG
+	ImageFormatWebP
+)
+
+var ImageFormatNames = map[string]ImageFormat{
+	"png":  ImageFormatPNG,
+	"jpeg": ImageFormatJPEG,
+	"webp": ImageFormatWebP,
+}
+
+// ParseImageFormat returns the format with the given name. An empty name
+// selects PNG.
+func ParseImageFormat(name string) (ImageFormat, error) {
+	if name == "" {
+		return ImageFormatPNG, nil
+	}
+
+	if format, ok := ImageFormatNames[name]; ok {
+		return format, nil
+	}
+
+	return ImageFormatPNG, fmt.Errorf("invalid image format: `%s`", name)
+}
+
+// Extension returns the file name extension of the format, including the dot
+func (f ImageFormat) Extension() string {
+	switch f {
+	case ImageFormatJPEG:
+		return ".jpg"
+	case ImageFormatWebP:
+		return ".webp"
+	default:
+		return ".png"
+	}
+}
+
+// SupportsAlpha reports whether images saved in the format keep transparency
+func (f ImageFormat) SupportsAlpha() bool {
+	return f != ImageFormatJPEG
+}
+
+// ForImage returns the format img should be saved in. Images with
+// transparent pixels are saved as PNG instead of formats without alpha.
+func (f ImageFormat) ForImage(img *image.NRGBA) ImageFormat {
+	if !f.SupportsAlpha() && !img.Opaque() {
+		return ImageFormatPNG
+	}
+	return f
+}
+
+// SaveImage writes img to the file name in format. quality is only used by
+// lossy formats.
+func SaveImage(img *image.NRGBA, name string, format ImageFormat, quality int) error {
+	switch format {
+	case ImageFormatJPEG:
+		return SaveJPEG(img, name, quality)
+	case ImageFormatWebP:
+		return SaveWebP(img, name, quality)
+	default:
+		return SavePNG(img, name)
+	}
+}
+
+// LoadImage reads an image saved by SaveImage in format
+func LoadImage(name string, format ImageFormat) (*image.NRGBA, error) {
+	switch format {
+	case ImageFormatJPEG:
+		return LoadJPEG(name)
+	case ImageFormatWebP:
+		return LoadWebP(name)
+	default:
+		return LoadPNG(name)
+	}
+}
+
+// saveAtomically creates the file name, including its directory, and fills
+// it with encode. The data is written to a temporary file first, so that an
+// interrupted save never leaves a truncated file.
+func saveAtomically(name string, encode func(w io.Writer) error) error {
+	err := os.MkdirAll(filepath.Dir(name), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	temp := name + ".tmp"
+	file, err := os.Create(temp)
+	if err != nil {
+		return err
+	}
+
+	if err := encode(file); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temp, name)
+}
@@ -0,0 +1,51 @@
+package raster
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"os"
+	"sync"
+)
+
+// flattenedImages holds opaque copies of images made by SaveJPEG, so that
+// workers saving tiles of the same size reuse them
+var flattenedImages sync.Pool
+
+func LoadJPEG(path string) (*image.NRGBA, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return DecodeJPEG(file)
+}
+
+func DecodeJPEG(r io.Reader) (*image.NRGBA, error) {
+	img, err := jpeg.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return toNRGBA(img), nil
+}
+
+// SaveJPEG writes img to the file name with quality from 1 to 100. JPEG has
+// no transparency, so transparent parts are drawn over black.
+func SaveJPEG(img *image.NRGBA, name string, quality int) error {
+	flattened, _ := flattenedImages.Get().(*image.RGBA)
+	if flattened == nil || flattened.Rect != img.Rect {
+		flattened = image.NewRGBA(img.Rect)
+	}
+	defer flattenedImages.Put(flattened)
+
+	draw.Draw(flattened, flattened.Rect, image.NewUniform(color.Black), image.Point{}, draw.Src)
+	draw.Draw(flattened, flattened.Rect, img, img.Rect.Min, draw.Over)
+
+	return saveAtomically(name, func(w io.Writer) error {
+		return jpeg.Encode(w, flattened, &jpeg.Options{Quality: quality})
+	})
+}
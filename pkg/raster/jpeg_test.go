@@ -0,0 +1,18 @@
+package raster
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func BenchmarkSaveJPEG(b *testing.B) {
+	img := testTile()
+	name := filepath.Join(b.TempDir(), "tile.jpg")
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := SaveJPEG(img, name, 90); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
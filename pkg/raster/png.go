@@ -4,8 +4,9 @@ import (
 	"image"
 	"image/draw"
 	"image/png"
+	"io"
 	"os"
-	"path/filepath"
+	"sync"
 )
 
 func toNRGBA(img image.Image) *image.NRGBA {
@@ -21,7 +22,11 @@ func LoadPNG(path string) (*image.NRGBA, error) {
 	}
 	defer file.Close()
 
-	img, err := png.Decode(file)
+	return DecodePNG(file)
+}
+
+func DecodePNG(r io.Reader) (*image.NRGBA, error) {
+	img, err := png.Decode(r)
 	if err != nil {
 		return nil, err
 	}
@@ -29,28 +34,31 @@ func LoadPNG(path string) (*image.NRGBA, error) {
 	return toNRGBA(img), nil
 }
 
-func SavePNG(img *image.NRGBA, name string) error {
-	err := os.MkdirAll(filepath.Dir(name), os.ModePerm)
-	if err != nil {
-		return err
-	}
+// pngBufferPool lets PNG encoders reuse their compressors and row buffers,
+// which are much larger than a tile, instead of allocating them for every
+// saved image
+type pngBufferPool struct {
+	pool sync.Pool
+}
 
-	file, err := os.Create(name)
-	if err != nil {
-		return err
-	}
+func (p *pngBufferPool) Get() *png.EncoderBuffer {
+	buffer, _ := p.pool.Get().(*png.EncoderBuffer)
+	return buffer
+}
 
-	encoder := png.Encoder{
-		CompressionLevel: png.BestCompression,
-	}
-	if err := encoder.Encode(file, img); err != nil {
-		file.Close()
-		return err
-	}
+func (p *pngBufferPool) Put(buffer *png.EncoderBuffer) {
+	p.pool.Put(buffer)
+}
 
-	if err := file.Close(); err != nil {
-		return err
-	}
+var pngBuffers = &pngBufferPool{}
 
-	return nil
+// SavePNG writes img to the file name, see saveAtomically
+func SavePNG(img *image.NRGBA, name string) error {
+	return saveAtomically(name, func(w io.Writer) error {
+		encoder := png.Encoder{
+			CompressionLevel: png.BestCompression,
+			BufferPool:       pngBuffers,
+		}
+		return encoder.Encode(w, img)
+	})
 }
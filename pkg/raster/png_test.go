@@ -0,0 +1,36 @@
+package raster
+
+import (
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+)
+
+// testTile returns a tile sized image with a gradient and a transparent
+// corner, like the edge of a map
+func testTile() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 256, 256))
+	for y := 0; y < 256; y++ {
+		for x := 0; x < 256; x++ {
+			if x+y < 64 {
+				continue
+			}
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(y), uint8(x ^ y), 255})
+		}
+	}
+
+	return img
+}
+
+func BenchmarkSavePNG(b *testing.B) {
+	img := testTile()
+	name := filepath.Join(b.TempDir(), "tile.png")
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := SavePNG(img, name); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -19,6 +19,15 @@ func NewRenderBuffer(rect image.Rectangle) *RenderBuffer {
 	}
 }
 
+// Clear makes the buffer empty again, so that it can be reused
+func (target *RenderBuffer) Clear() {
+	for i := range target.Color.Pix {
+		target.Color.Pix[i] = 0
+	}
+	target.Depth.Clear()
+	target.Dirty = false
+}
+
 func (target *RenderBuffer) OverlayDepthAwareWithAlpha(source *RenderBuffer, origin image.Point, depthOffset float64) {
 	target.Dirty = true
 	if source == nil {
@@ -0,0 +1,174 @@
+package raster
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+)
+
+const (
+	tgaTypeTrueColor    = 2
+	tgaTypeGrayscale    = 3
+	tgaTypeTrueColorRLE = 10
+	tgaTypeGrayscaleRLE = 11
+
+	tgaRightToLeft = 0x10
+	tgaTopToBottom = 0x20
+
+	// maxTGAPixels limits the memory allocated for an image before any of
+	// its pixels are read, since the header alone can claim 65535x65535
+	maxTGAPixels = 4096 * 4096
+)
+
+type tgaHeader struct {
+	IDLength     uint8
+	ColorMapType uint8
+	ImageType    uint8
+	ColorMap     [5]byte
+	XOrigin      uint16
+	YOrigin      uint16
+	Width        uint16
+	Height       uint16
+	PixelDepth   uint8
+	Descriptor   uint8
+}
+
+// DecodeTGA decodes uncompressed and RLE-compressed true color (24 and 32 bit)
+// and grayscale (8 bit) TGA images
+func DecodeTGA(r io.Reader) (*image.NRGBA, error) {
+	reader := bufio.NewReader(r)
+
+	var header tgaHeader
+	err := binary.Read(reader, binary.LittleEndian, &header)
+	if err != nil {
+		return nil, err
+	}
+
+	if header.ColorMapType != 0 {
+		return nil, errors.New("color-mapped TGA images are not supported")
+	}
+
+	var compressed bool
+	switch header.ImageType {
+	case tgaTypeTrueColor, tgaTypeGrayscale:
+		compressed = false
+	case tgaTypeTrueColorRLE, tgaTypeGrayscaleRLE:
+		compressed = true
+	default:
+		return nil, fmt.Errorf("unsupported TGA image type: %v", header.ImageType)
+	}
+
+	grayscale := header.ImageType == tgaTypeGrayscale || header.ImageType == tgaTypeGrayscaleRLE
+	switch {
+	case grayscale && header.PixelDepth == 8:
+	case !grayscale && (header.PixelDepth == 24 || header.PixelDepth == 32):
+	default:
+		return nil, fmt.Errorf("unsupported TGA pixel depth: %v", header.PixelDepth)
+	}
+
+	_, err = reader.Discard(int(header.IDLength))
+	if err != nil {
+		return nil, err
+	}
+
+	width, height := int(header.Width), int(header.Height)
+	if width == 0 || height == 0 {
+		return nil, fmt.Errorf("invalid TGA image size: %vx%v", width, height)
+	}
+	if width*height > maxTGAPixels {
+		return nil, fmt.Errorf("TGA image size %vx%v exceeds %v pixels", width, height, maxTGAPixels)
+	}
+
+	bytesPerPixel := int(header.PixelDepth / 8)
+
+	// Pixels are decoded in file order first and rearranged afterwards
+	pixels := make([]byte, width*height*bytesPerPixel)
+	if compressed {
+		err = readTGARLE(reader, pixels, bytesPerPixel)
+	} else {
+		_, err = io.ReadFull(reader, pixels)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < width*height; i++ {
+		x, y := i%width, i/width
+		if header.Descriptor&tgaRightToLeft != 0 {
+			x = width - 1 - x
+		}
+		// Bottom-to-top is the default
+		if header.Descriptor&tgaTopToBottom == 0 {
+			y = height - 1 - y
+		}
+
+		src := pixels[i*bytesPerPixel : (i+1)*bytesPerPixel]
+		dst := img.Pix[img.PixOffset(x, y):]
+		if grayscale {
+			dst[0], dst[1], dst[2], dst[3] = src[0], src[0], src[0], 255
+			continue
+		}
+
+		// Color components are stored in BGR(A) order
+		dst[0], dst[1], dst[2], dst[3] = src[2], src[1], src[0], 255
+		if bytesPerPixel == 4 {
+			dst[3] = src[3]
+		}
+	}
+
+	return img, nil
+}
+
+// readTGARLE decompresses run-length encoded pixels until pixels is filled
+func readTGARLE(reader *bufio.Reader, pixels []byte, bytesPerPixel int) error {
+	for offset := 0; offset < len(pixels); {
+		packet, err := reader.ReadByte()
+		if err != nil {
+			return err
+		}
+
+		count := int(packet&0x7F) + 1
+		if offset+count*bytesPerPixel > len(pixels) {
+			return errors.New("TGA run exceeds image size")
+		}
+
+		if packet&0x80 == 0 {
+			// Raw packet: count pixels follow
+			_, err = io.ReadFull(reader, pixels[offset:offset+count*bytesPerPixel])
+			if err != nil {
+				return err
+			}
+			offset += count * bytesPerPixel
+			continue
+		}
+
+		// Run-length packet: a single pixel repeated count times
+		pixel := pixels[offset : offset+bytesPerPixel]
+		_, err = io.ReadFull(reader, pixel)
+		if err != nil {
+			return err
+		}
+		offset += bytesPerPixel
+		for i := 1; i < count; i++ {
+			copy(pixels[offset:], pixel)
+			offset += bytesPerPixel
+		}
+	}
+
+	return nil
+}
+
+func LoadTGA(path string) (*image.NRGBA, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return DecodeTGA(file)
+}
@@ -0,0 +1,42 @@
+package raster
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func tgaImage(width, height uint16, pixels []byte) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, tgaHeader{
+		ImageType:  tgaTypeTrueColor,
+		Width:      width,
+		Height:     height,
+		PixelDepth: 32,
+		Descriptor: tgaTopToBottom,
+	})
+	buf.Write(pixels)
+	return buf.Bytes()
+}
+
+func TestDecodeTGA(t *testing.T) {
+	// BGRA pixels
+	img, err := DecodeTGA(bytes.NewReader(tgaImage(2, 1, []byte{1, 2, 3, 4, 5, 6, 7, 8})))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte{3, 2, 1, 4, 7, 6, 5, 8}
+	if img.Bounds().Dx() != 2 || img.Bounds().Dy() != 1 || !bytes.Equal(img.Pix, want) {
+		t.Errorf("got %v with pixels %v, want 2x1 with %v", img.Bounds(), img.Pix, want)
+	}
+}
+
+func TestDecodeTGAInvalidSize(t *testing.T) {
+	for _, size := range [][2]uint16{{0, 16}, {16, 0}, {65535, 65535}, {4097, 4096}} {
+		_, err := DecodeTGA(bytes.NewReader(tgaImage(size[0], size[1], nil)))
+		if err == nil {
+			t.Errorf("decoding %vx%v image succeeded", size[0], size[1])
+		}
+	}
+}
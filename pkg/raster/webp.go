@@ -0,0 +1,36 @@
+package raster
+
+import (
+	"image"
+	"io"
+	"os"
+
+	"github.com/chai2010/webp"
+)
+
+func LoadWebP(path string) (*image.NRGBA, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return DecodeWebP(file)
+}
+
+func DecodeWebP(r io.Reader) (*image.NRGBA, error) {
+	img, err := webp.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return toNRGBA(img), nil
+}
+
+// SaveWebP writes img to the file name as lossy WebP with quality from 1 to
+// 100. Unlike JPEG, transparency is kept.
+func SaveWebP(img *image.NRGBA, name string, quality int) error {
+	return saveAtomically(name, func(w io.Writer) error {
+		return webp.Encode(w, img, &webp.Options{Quality: float32(quality)})
+	})
+}
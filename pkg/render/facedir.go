@@ -0,0 +1,77 @@
+package render
+
+import (
+	"math"
+
+	"github.com/weqqr/panorama/pkg/lm"
+)
+
+// faceDirRotations contains rotation matrices for all 24 facedir values
+var faceDirRotations [24]lm.Matrix3
+
+func init() {
+	basis := []lm.Vector3{
+		lm.Vec3(1, 0, 0),
+		lm.Vec3(0, 1, 0),
+		lm.Vec3(0, 0, 1),
+	}
+
+	for facedir := range faceDirRotations {
+		// Columns of the matrix are images of the basis vectors. Rotations are
+		// by multiples of 90 degrees, so rounding only removes float errors.
+		var m [9]float64
+		for col, v := range basis {
+			v = transformToFaceDir(v, uint8(facedir))
+			m[col] = math.Round(v.X)
+			m[3+col] = math.Round(v.Y)
+			m[6+col] = math.Round(v.Z)
+		}
+
+		faceDirRotations[facedir] = lm.NewMatrix3(m)
+	}
+}
+
+// FaceDirRotation returns the rotation of nodes with facedir value ranging
+// from 0 to 23. The lower 2 bits select rotation around the Y axis, and the
+// upper 3 bits select the direction the top of the node faces. Invalid values
+// aren't rotated.
+func FaceDirRotation(facedir uint8) lm.Matrix3 {
+	if int(facedir) >= len(faceDirRotations) {
+		facedir = 0
+	}
+
+	return faceDirRotations[facedir]
+}
+
+func transformToFaceDir(v lm.Vector3, facedir uint8) lm.Vector3 {
+	axis := (facedir >> 2) & 0x7
+	dir := facedir & 0x3
+
+	// Left click with screwdriver
+	switch dir {
+	case 0: // no-op
+	case 1:
+		v = v.RotateXZ(lm.Radians(-90))
+	case 2:
+		v = v.RotateXZ(lm.Radians(180))
+	case 3:
+		v = v.RotateXZ(lm.Radians(90))
+	}
+
+	// Right click with screwdriver
+	switch axis {
+	case 0: // no-op
+	case 1:
+		v = v.RotateYZ(lm.Radians(90))
+	case 2:
+		v = v.RotateYZ(lm.Radians(-90))
+	case 3:
+		v = v.RotateXY(lm.Radians(-90))
+	case 4:
+		v = v.RotateXY(lm.Radians(90))
+	case 5:
+		v = v.RotateXY(lm.Radians(180))
+	}
+
+	return v
+}
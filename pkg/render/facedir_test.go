@@ -0,0 +1,27 @@
+package render
+
+import (
+	"testing"
+
+	"github.com/weqqr/panorama/pkg/game"
+	"github.com/weqqr/panorama/pkg/lm"
+)
+
+func TestWallMountedRotation(t *testing.T) {
+	nd := game.NodeDefinition{ParamType2: game.ParamType2WallMounted}
+
+	// The bottom of a wallmounted node faces the wall it's attached to
+	for wallMounted, want := range []lm.Vector3{
+		lm.Vec3(0, 1, 0),
+		lm.Vec3(0, -1, 0),
+		lm.Vec3(1, 0, 0),
+		lm.Vec3(-1, 0, 0),
+		lm.Vec3(0, 0, 1),
+		lm.Vec3(0, 0, -1),
+	} {
+		rotation := FaceDirRotation(nd.FaceDir(uint8(wallMounted)))
+		if got := rotation.MulVec(lm.Vec3(0, -1, 0)); got != want {
+			t.Errorf("wallmounted %v sends -Y to %v, want %v", wallMounted, got, want)
+		}
+	}
+}
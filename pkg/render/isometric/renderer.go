@@ -1,7 +1,10 @@
 package isometric
 
 import (
+	"context"
+	"fmt"
 	"image"
+	"image/color"
 	"math"
 
 	"github.com/weqqr/panorama/pkg/game"
@@ -24,14 +27,249 @@ type Renderer struct {
 
 	region spatial.Region
 	game   *game.Game
+
+	options render.Options
+
+	// target and neighborhood are reused between tiles, so a renderer must
+	// not be shared by several goroutines
+	target       *raster.RenderBuffer
+	neighborhood render.BlockNeighborhood
+
+	// colorNodes contains definitions of nodes colored by
+	// options.NodeColor
+	colorNodes map[color.NRGBA]game.NodeDefinition
 }
 
-func NewRenderer(region spatial.Region, game *game.Game) *Renderer {
+func NewRenderer(region spatial.Region, game *game.Game, options render.Options) *Renderer {
 	return &Renderer{
-		nr:     render.NewNodeRasterizer(lm.DimetricProjection()),
-		region: region,
-		game:   game,
+		nr:      render.NewNodeRasterizer(lm.DimetricProjection(), options),
+		region:  region,
+		game:    game,
+		options: options,
+		target:  raster.NewRenderBuffer(image.Rect(0, 0, TileBlockWidth, TileBlockWidth)),
+	}
+}
+
+// colorNode returns the definition of a cube of color c
+func (r *Renderer) colorNode(c color.NRGBA) game.NodeDefinition {
+	if r.colorNodes == nil {
+		r.colorNodes = make(map[color.NRGBA]game.NodeDefinition)
+	}
+
+	nodeDef, ok := r.colorNodes[c]
+	if !ok {
+		nodeDef = game.ColorNode(c)
+		r.colorNodes[c] = nodeDef
+	}
+
+	return nodeDef
+}
+
+// brightness returns how bright a node lit with light level is
+func (r *Renderer) brightness(light uint8) float64 {
+	if r.options.FullBright {
+		return 1
+	}
+
+	return render.LightBrightness(light, r.options.LightGamma)
+}
+
+// computeConnectedEdges finds edges of visible faces of framed glass at pos
+// that continue into a face of an adjacent node of the same kind. Frames
+// aren't drawn along these edges, so that connected glass looks like a single
+// pane.
+func (r *Renderer) computeConnectedEdges(neighborhood *render.BlockNeighborhood, pos spatial.NodePosition, name string, hiddenFaces mesh.CubeFaces) [6]render.FaceEdges {
+	var edges [6]render.FaceEdges
+
+	for _, face := range occlusionFaces {
+		if hiddenFaces&face.face != 0 {
+			continue
+		}
+
+		directions := []struct {
+			edge   render.FaceEdges
+			offset spatial.NodePosition
+		}{
+			{render.FaceEdgeV0, spatial.NodePosition{}.Sub(face.v)},
+			{render.FaceEdgeV1, face.v},
+			{render.FaceEdgeU0, spatial.NodePosition{}.Sub(face.u)},
+			{render.FaceEdgeU1, face.u},
+		}
+
+		for _, direction := range directions {
+			neighborPos := pos.Add(direction.offset)
+
+			// The adjacent face is visible only if the node in front of it
+			// doesn't cover it
+			sameNeighbor := neighborhood.GetResolvedNode(neighborPos).Name == name
+			covered := neighborhood.GetResolvedNode(neighborPos.Add(face.normal)).Name == name
+			if sameNeighbor && !covered {
+				edges[face.index] |= direction.edge
+			}
+		}
+	}
+
+	return edges
+}
+
+// liquidLevel returns how much of the node is filled with liquid, from 0 to 1.
+// Flowing liquids store their level in the lower 3 bits of param2, and the
+// 4th bit is set for liquid flowing down, which fills the entire node.
+func liquidLevel(nodeDef *game.NodeDefinition, param2 uint8) float64 {
+	if nodeDef.DrawType != game.DrawTypeFlowingLiquid || param2&0x08 != 0 {
+		return 1
+	}
+
+	return float64(param2&0x07+1) / 8
+}
+
+// liquidLevelAt returns the level of the liquid at pos. The second value is
+// false if the node isn't a liquid, as are nodes in missing blocks.
+func (r *Renderer) liquidLevelAt(neighborhood *render.BlockNeighborhood, pos spatial.NodePosition) (float64, bool) {
+	node := neighborhood.GetResolvedNode(pos)
+	if node.Name == "air" || node.Name == "ignore" {
+		return 0, false
+	}
+
+	nodeDef := r.game.NodeDef(node.Name)
+	if !nodeDef.DrawType.IsLiquid() {
+		return 0, false
+	}
+
+	return liquidLevel(&nodeDef, node.Param2), true
+}
+
+// computeLiquidHeights computes heights of the top corners of the liquid at
+// pos by averaging levels of liquids sharing each corner, so that surfaces of
+// adjacent nodes connect. Liquids covered by another liquid are full.
+func (r *Renderer) computeLiquidHeights(neighborhood *render.BlockNeighborhood, pos spatial.NodePosition) [4]uint8 {
+	var heights [4]uint8
+
+	up := spatial.NodePosition{Y: 1}
+	if _, covered := r.liquidLevelAt(neighborhood, pos.Add(up)); covered {
+		return heights
+	}
+
+	full := true
+	for corner := range heights {
+		dx, dz := -1, -1
+		if corner&1 != 0 {
+			dx = 1
+		}
+		if corner&2 != 0 {
+			dz = 1
+		}
+
+		columns := []spatial.NodePosition{
+			{X: 0, Z: 0},
+			{X: dx, Z: 0},
+			{X: 0, Z: dz},
+			{X: dx, Z: dz},
+		}
+
+		level, count := 0.0, 0
+		for _, column := range columns {
+			columnLevel, ok := r.liquidLevelAt(neighborhood, pos.Add(column))
+			if !ok {
+				continue
+			}
+
+			if _, covered := r.liquidLevelAt(neighborhood, pos.Add(column).Add(up)); covered {
+				level, count = 1, 1
+				break
+			}
+
+			level += columnLevel
+			count++
+		}
+
+		heights[corner] = uint8(math.Round(level / float64(count) * render.LiquidHeightUnits))
+		if heights[corner] != render.LiquidHeightUnits {
+			full = false
+		}
+	}
+
+	// Full liquids are drawn as regular cubes
+	if full {
+		return [4]uint8{}
 	}
+
+	return heights
+}
+
+// occlusionFaces lists cube faces visible from the camera together with their
+// normals. u and v point towards the corners with the respective texture
+// coordinate equal to 1.
+var occlusionFaces = []struct {
+	index  int
+	face   mesh.CubeFaces
+	normal spatial.NodePosition
+	u, v   spatial.NodePosition
+}{
+	{0, mesh.CubeFaceTop, spatial.NodePosition{Y: 1}, spatial.NodePosition{X: 1}, spatial.NodePosition{Z: 1}},
+	{2, mesh.CubeFaceEast, spatial.NodePosition{X: 1}, spatial.NodePosition{Z: -1}, spatial.NodePosition{Y: -1}},
+	{4, mesh.CubeFaceNorth, spatial.NodePosition{Z: 1}, spatial.NodePosition{X: 1}, spatial.NodePosition{Y: 1}},
+}
+
+// isOccluder reports whether the node at pos darkens adjacent corners
+func (r *Renderer) isOccluder(neighborhood *render.BlockNeighborhood, pos, worldPos spatial.NodePosition) bool {
+	node := neighborhood.GetResolvedNode(pos)
+	if node.Name == "air" || node.Name == "ignore" {
+		return false
+	}
+
+	if !r.region.Intersects(worldPos.Region()) {
+		return false
+	}
+
+	nodeDef := r.game.NodeDef(node.Name)
+	return nodeDef.IsOpaqueCube()
+}
+
+// computeOcclusion computes ambient occlusion of visible faces of the cube at
+// pos. Each corner is darkened according to the number of solid nodes touching
+// it in front of the face.
+func (r *Renderer) computeOcclusion(neighborhood *render.BlockNeighborhood, pos, worldPos spatial.NodePosition, hiddenFaces mesh.CubeFaces) [6]render.FaceOcclusion {
+	var occlusion [6]render.FaceOcclusion
+
+	for _, face := range occlusionFaces {
+		if hiddenFaces&face.face != 0 {
+			continue
+		}
+
+		for corner := 0; corner < 4; corner++ {
+			u, v := face.u, face.v
+			if corner&1 == 0 {
+				u = spatial.NodePosition{}.Sub(u)
+			}
+			if corner&2 == 0 {
+				v = spatial.NodePosition{}.Sub(v)
+			}
+
+			isOccluder := func(offset spatial.NodePosition) bool {
+				offset = offset.Add(face.normal)
+				return r.isOccluder(neighborhood, pos.Add(offset), worldPos.Add(offset))
+			}
+
+			side1 := isOccluder(u)
+			side2 := isOccluder(v)
+
+			// Two solid sides hide the corner node completely
+			level := render.MaxOcclusion
+			if !side1 || !side2 {
+				level = 0
+				for _, solid := range []bool{side1, side2, isOccluder(u.Add(v))} {
+					if solid {
+						level++
+					}
+				}
+			}
+
+			occlusion[face.index] = occlusion[face.index].WithCorner(corner, level)
+		}
+	}
+
+	return occlusion
 }
 
 func (r *Renderer) renderNode(
@@ -42,20 +280,26 @@ func (r *Renderer) renderNode(
 	offset image.Point,
 	depthOffset float64,
 ) {
-	name, param1, param2 := neighborhood.GetNode(pos)
+	node := neighborhood.GetResolvedNode(pos)
 
-	// Fast path: checking for air immediately is faster than fetching NodeDefinition
-	if name == "air" {
+	// Fast path: checking for air immediately is faster than fetching NodeDefinition.
+	// Ignore fills missing parts of the map and is never drawn either.
+	if node.Name == "air" || node.Name == "ignore" {
 		return
 	}
 
-	nodeDef := r.game.NodeDef(name)
+	nodeDef := r.game.NodeDef(node.Name)
 
-	needsAlphaBlending := true
-	if nodeDef.DrawType == game.DrawTypeNormal {
-		needsAlphaBlending = false
+	var nodeColor color.NRGBA
+	if r.options.NodeColor != nil {
+		if c, ok := r.options.NodeColor(node.Name, node.Param1, node.Param2); ok {
+			nodeDef = r.colorNode(c)
+			nodeColor = c
+		}
 	}
 
+	needsAlphaBlending := nodeDef.NeedsAlphaBlending()
+
 	// Estimate lighting by sampling neighboring nodes and using the brightest one
 	neighborOffsets := []spatial.NodePosition{
 		{X: 1, Y: 0, Z: 0},
@@ -69,38 +313,110 @@ func (r *Renderer) renderNode(
 		mesh.CubeFaceNorth,
 	}
 
-	maxParam1 := param1
+	// Faces of rotated cubes don't match their neighbors, so they aren't culled
+	isCube := nodeDef.DrawType == game.DrawTypeNormal || nodeDef.DrawType.IsGlasslike()
+	cullable := isCube && nodeDef.FaceDir(node.Param2) == 0
+
+	// hidesFace reports whether the neighbor at offset covers the face of a
+	// cube touching it
+	hidesFace := func(neighbor render.ResolvedNode, neighborNodeDef *game.NodeDefinition, offset spatial.NodePosition) bool {
+		// Opaque cubes cut off by the region boundary aren't drawn
+		if neighborNodeDef.IsOpaqueCube() && r.region.Intersects(worldPos.Add(offset).Region()) {
+			return true
+		}
+
+		// Glass nodes of the same kind form a single surface
+		return nodeDef.DrawType.IsGlasslike() && neighbor.Name == node.Name
+	}
+
+	maxLight := render.DayLight(node.Param1)
 	hiddenFaces := mesh.CubeFaces(0)
+	if nodeDef.DrawType.IsLiquid() || (cullable && nodeDef.EffectiveAlphaMode() == game.AlphaModeOpaque) {
+		// These faces are never visible from the camera
+		hiddenFaces |= mesh.CubeFaceWest | mesh.CubeFaceDown | mesh.CubeFaceSouth
+	} else if cullable {
+		// Back faces can be seen through cubes with transparent textures, so
+		// they're only hidden by neighbors
+		backOffsets := []spatial.NodePosition{
+			{X: -1, Y: 0, Z: 0},
+			{X: 0, Y: -1, Z: 0},
+			{X: 0, Y: 0, Z: -1},
+		}
+
+		backFaces := []mesh.CubeFaces{
+			mesh.CubeFaceWest,
+			mesh.CubeFaceDown,
+			mesh.CubeFaceSouth,
+		}
+
+		for i, offset := range backOffsets {
+			neighbor := neighborhood.GetResolvedNode(pos.Add(offset))
+			if neighbor.Name == "air" || neighbor.Name == "ignore" {
+				continue
+			}
+
+			neighborNodeDef := r.game.NodeDef(neighbor.Name)
+			if hidesFace(neighbor, &neighborNodeDef, offset) {
+				hiddenFaces |= backFaces[i]
+			}
+		}
+	}
+
 	for i, offset := range neighborOffsets {
 		neighborPos := pos.Add(offset)
-		neighborName, param1, _ := neighborhood.GetNode(neighborPos)
-		if param1 > maxParam1 {
-			maxParam1 = param1
+		neighbor := neighborhood.GetResolvedNode(neighborPos)
+		if light := render.DayLight(neighbor.Param1); light > maxLight {
+			maxLight = light
 		}
 
+		// Missing blocks are filled with ignore, which must not hide anything
+		if neighbor.Name == "air" || neighbor.Name == "ignore" {
+			continue
+		}
+
+		neighborNodeDef := r.game.NodeDef(neighbor.Name)
+
 		// Compute visibility for stacked liquids
-		if nodeDef.DrawType.IsLiquid() {
-			hiddenFaces |= mesh.CubeFaceWest | mesh.CubeFaceDown | mesh.CubeFaceSouth
+		if nodeDef.DrawType.IsLiquid() && neighborNodeDef.DrawType.IsLiquid() {
+			hiddenFaces |= neighborFaces[i]
+		}
 
-			neighborNodeDef := r.game.NodeDef(neighborName)
-			if neighborNodeDef.DrawType.IsLiquid() {
-				hiddenFaces |= neighborFaces[i]
-			}
+		if cullable && hidesFace(neighbor, &neighborNodeDef, offset) {
+			hiddenFaces |= neighborFaces[i]
 		}
 	}
 
+	// Skip nodes that are completely hidden by their neighbors
+	if cullable && hiddenFaces == mesh.CubeFaceAll {
+		return
+	}
+
 	// Make underground edges visible (otherwise the edge becomes oddly thin and
 	// that doesn't look good)
-	if r.region.IsAtEdge(worldPos) && maxParam1 == render.ZeroIntensity {
-		maxParam1 = render.MapEdgeIntensity
+	if r.region.IsAtEdge(worldPos) && maxLight == render.ZeroIntensity {
+		maxLight = render.MapEdgeIntensity
 	}
 
 	renderableNode := render.RenderableNode{
-		Name:        name,
-		Light:       render.DecodeLight(maxParam1),
-		Param2:      param2,
+		Name:        node.Name,
+		Light:       r.brightness(maxLight),
+		Param2:      node.Param2,
 		HiddenFaces: hiddenFaces,
+		Color:       nodeColor,
+	}
+
+	if r.options.AmbientOcclusion > 0 && cullable {
+		renderableNode.Occlusion = r.computeOcclusion(neighborhood, pos, worldPos, hiddenFaces)
 	}
+
+	if cullable && nodeDef.DrawType == game.DrawTypeGlasslikeFramed {
+		renderableNode.ConnectedEdges = r.computeConnectedEdges(neighborhood, pos, node.Name, hiddenFaces)
+	}
+
+	if nodeDef.DrawType.IsLiquid() {
+		renderableNode.LiquidHeights = r.computeLiquidHeights(neighborhood, pos)
+	}
+
 	renderedNode := r.nr.Render(renderableNode, &nodeDef)
 
 	depthOffset = -float64(pos.Z+pos.X)/math.Sqrt2 - 0.5*(float64(pos.Y)) + depthOffset
@@ -111,6 +427,27 @@ func (r *Renderer) renderNode(
 	}
 }
 
+// isSolidBlock reports whether block consists of a single kind of opaque
+// cubes that are never rotated, so that faces between them are always culled
+func (r *Renderer) isSolidBlock(block *world.MapBlock) bool {
+	if block == nil {
+		return false
+	}
+
+	name, ok := block.IsUniform()
+	if !ok || name == "air" || name == "ignore" {
+		return false
+	}
+
+	nodeDef := r.game.NodeDef(name)
+	switch nodeDef.ParamType2 {
+	case game.ParamType2FaceDir, game.ParamType2ColorFaceDir, game.ParamType2WallMounted, game.ParamType2ColorWallMounted:
+		return false
+	}
+
+	return nodeDef.IsOpaqueCube()
+}
+
 func (r *Renderer) renderBlock(
 	target *raster.RenderBuffer,
 	blockPos spatial.BlockPosition,
@@ -123,6 +460,8 @@ func (r *Renderer) renderBlock(
 	// FIXME: nodes must define their origin points
 	originX, originY := rect.Dx()/2-render.BaseResolution/2, rect.Dy()/2+render.BaseResolution/4+2
 
+	solid := r.isSolidBlock(neighborhood.CenterBlock())
+
 	for z := spatial.BlockSize - 1; z >= 0; z-- {
 		for y := spatial.BlockSize - 1; y >= 0; y-- {
 			for x := spatial.BlockSize - 1; x >= 0; x-- {
@@ -133,6 +472,13 @@ func (r *Renderer) renderBlock(
 					continue
 				}
 
+				// Inside blocks of opaque cubes, nodes are completely hidden
+				// by their neighbors unless the region cuts them off
+				if solid && x < spatial.BlockSize-1 && y < spatial.BlockSize-1 && z < spatial.BlockSize-1 &&
+					r.region.Intersects(nodeWorldPos.Add(spatial.NodePosition{X: 1, Y: 1, Z: 1}).Region()) {
+					continue
+				}
+
 				offset := image.Point{
 					X: originX + render.BaseResolution*(z-x)/2 + offset.X,
 					Y: originY + render.BaseResolution*(z+x)/4 + offset.Y - YOffsetCoef*y,
@@ -144,15 +490,18 @@ func (r *Renderer) renderBlock(
 	}
 }
 
+// RenderTile draws the tile at tilePos. The returned buffer is reused by the
+// next call.
 func (r *Renderer) RenderTile(
+	ctx context.Context,
 	tilePos render.TilePosition,
 	world *world.World,
 	game *game.Game,
-) *raster.RenderBuffer {
+) (*raster.RenderBuffer, error) {
 	tilePos.Y *= 2
 
-	rect := image.Rect(0, 0, TileBlockWidth, TileBlockWidth)
-	target := raster.NewRenderBuffer(rect)
+	target := r.target
+	target.Clear()
 
 	centerX := tilePos.Y - tilePos.X
 	centerY := 0
@@ -170,25 +519,94 @@ func (r *Renderer) RenderTile(
 					Z: centerZ + z + i,
 				}
 
-				neighborhood := render.BlockNeighborhood{}
+				// Blocks outside the region have nothing to draw, so they
+				// aren't fetched at all
+				if !r.region.Intersects(blockPos.Region()) {
+					continue
+				}
 
-				neighborhood.FetchBlock(world, spatial.BlockPosition{X: 0, Y: 0, Z: 0}, blockPos)
-				neighborhood.FetchBlock(world, spatial.BlockPosition{X: 1, Y: 0, Z: 0}, blockPos)
-				neighborhood.FetchBlock(world, spatial.BlockPosition{X: 0, Y: 1, Z: 0}, blockPos)
-				neighborhood.FetchBlock(world, spatial.BlockPosition{X: 0, Y: 0, Z: 1}, blockPos)
+				// Ambient occlusion and liquid surfaces depend on nodes on all
+				// sides, so the entire neighborhood is needed
+				err := r.neighborhood.Load(ctx, world, blockPos)
+				if err != nil {
+					// The caller keeps whatever was rendered previously
+					// instead of saving a tile with holes in it
+					return nil, fmt.Errorf("fetching neighborhood of block %v: %w", blockPos, err)
+				}
 
 				offset := image.Point{
 					X: render.BaseResolution * (z - x) / 2 * spatial.BlockSize,
 					Y: (render.BaseResolution*(z+x+2*i)/4 - i*YOffsetCoef) * spatial.BlockSize,
 				}
 
+				// Missing blocks and blocks of air have nothing to draw
+				if center := r.neighborhood.CenterBlock(); center == nil || center.IsAir() {
+					continue
+				}
+
 				depthOffset := (-float64(z+x+2*i)/math.Sqrt2 - 0.5*float64(i)) * spatial.BlockSize
-				r.renderBlock(target, blockPos, &neighborhood, offset, depthOffset)
+				r.renderBlock(target, blockPos, &r.neighborhood, offset, depthOffset)
 			}
 		}
 	}
 
-	return target
+	return target, nil
+}
+
+// RenderBlock draws a single block on its own, as if it were surrounded by
+// air. It's meant for debugging how nodes are drawn. The image is always
+// TileBlockWidth×TileBlockHeight pixels, with the block in the middle.
+func RenderBlock(block *world.MapBlock, game *game.Game, options render.Options) *image.NRGBA {
+	region := spatial.Region{
+		XBounds: spatial.Bounds{Min: 0, Max: spatial.BlockSize - 1},
+		YBounds: spatial.Bounds{Min: 0, Max: spatial.BlockSize - 1},
+		ZBounds: spatial.Bounds{Min: 0, Max: spatial.BlockSize - 1},
+	}
+	r := NewRenderer(region, game, options)
+
+	target := raster.NewRenderBuffer(image.Rect(0, 0, TileBlockWidth, TileBlockHeight))
+	r.renderBlock(target, spatial.BlockPosition{}, render.SingleBlockNeighborhood(block), image.Point{}, 0)
+
+	return target.Color
+}
+
+// TileBlocks lists positions of blocks drawn on the tile at tilePos, in the
+// same order as RenderTile draws them
+func (r *Renderer) TileBlocks(tilePos render.TilePosition) []spatial.BlockPosition {
+	tilePos.Y *= 2
+
+	centerX := tilePos.Y - tilePos.X
+	centerY := 0
+	centerZ := tilePos.Y + tilePos.X
+
+	yMin := int(math.Floor(float64(r.region.YBounds.Min) / float64(spatial.BlockSize)))
+	yMax := int(math.Ceil(float64(r.region.YBounds.Max) / float64(spatial.BlockSize)))
+
+	var positions []spatial.BlockPosition
+	for i := yMin; i < yMax; i++ {
+		for z := -3; z <= 3; z++ {
+			for x := -3; x <= 3; x++ {
+				blockPos := spatial.BlockPosition{
+					X: centerX + x + i,
+					Y: centerY + i,
+					Z: centerZ + z + i,
+				}
+
+				if r.region.Intersects(blockPos.Region()) {
+					positions = append(positions, blockPos)
+				}
+			}
+		}
+	}
+
+	return positions
+}
+
+// ReadBlocks lists positions of blocks drawn on the tile at tilePos together
+// with the blocks around them, since RenderTile loads the neighborhood of
+// every drawn block
+func (r *Renderer) ReadBlocks(tilePos render.TilePosition) []spatial.BlockPosition {
+	return render.NeighborhoodPositions(r.TileBlocks(tilePos))
 }
 
 func ProjectRegion(region spatial.Region) spatial.TileRegion {
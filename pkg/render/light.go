@@ -1,11 +1,23 @@
 package render
 
+import "math"
+
 const (
 	ZeroIntensity    = 0
 	MapEdgeIntensity = 11
 	FullIntensity    = 15
 )
 
+// DayLight extracts light level (0-15) during the day from param1
+func DayLight(param1 uint8) uint8 {
+	return param1 & 0xF
+}
+
+// NightLight extracts light level (0-15) during the night from param1
+func NightLight(param1 uint8) uint8 {
+	return (param1 >> 4) & 0xF
+}
+
 func DecodeLight(param1 uint8) float64 {
 	var LUT = [16]float64{
 		0.000,
@@ -25,5 +37,15 @@ func DecodeLight(param1 uint8) float64 {
 		0.918,
 		1.000,
 	}
-	return LUT[param1&0xF]
+	return LUT[DayLight(param1)]
+}
+
+// LightBrightness maps a light level (0-15) to brightness. If gamma is 0, the
+// light curve of Minetest is used, otherwise brightness is (level/15)^gamma.
+func LightBrightness(level uint8, gamma float64) float64 {
+	if gamma == 0 {
+		return DecodeLight(level)
+	}
+
+	return math.Pow(float64(DayLight(level))/FullIntensity, gamma)
 }
@@ -1,6 +1,10 @@
 package render
 
 import (
+	"context"
+	"errors"
+
+	"github.com/weqqr/panorama/pkg/logging"
 	"github.com/weqqr/panorama/pkg/spatial"
 	"github.com/weqqr/panorama/pkg/world"
 )
@@ -11,18 +15,130 @@ type BlockNeighborhood struct {
 
 var neighborhoodCenter = spatial.BlockPosition{X: 1, Y: 1, Z: 1}
 
+// blockIndex maps a position inside the 3x3x3 neighborhood to a slot in
+// blocks. Every access to blocks must go through it so that writes and reads
+// agree on the ordering.
 func blockIndex(pos spatial.BlockPosition) int {
 	return pos.Z*9 + pos.Y*3 + pos.X
 }
 
-func (b *BlockNeighborhood) FetchBlock(w *world.World, posOffset, worldPos spatial.BlockPosition) {
-	block, err := w.GetBlock(worldPos.Add(posOffset))
+// FetchBlock loads the block at worldPos+posOffset into the neighborhood slot
+// at posOffset. Missing and undecodable blocks leave the slot empty and aren't
+// reported as an error.
+func (b *BlockNeighborhood) FetchBlock(ctx context.Context, w *world.World, posOffset, worldPos spatial.BlockPosition) error {
+	block, err := w.GetBlock(ctx, worldPos.Add(posOffset))
+	if errors.Is(err, world.ErrBlockNotFound) {
+		return nil
+	}
+
+	if world.IsDecodeError(err) {
+		logging.Warnf("skipping block: %v", err)
+		return nil
+	}
 
 	if err != nil {
-		return
+		return err
 	}
 
 	b.SetBlock(neighborhoodCenter.Add(posOffset), block)
+	return nil
+}
+
+// Clear empties all slots so that the neighborhood can be reused for another
+// block. Nodes in empty slots are read as `ignore` and aren't drawn, the same
+// way as missing blocks.
+func (b *BlockNeighborhood) Clear() {
+	b.blocks = [27]*world.MapBlock{}
+}
+
+// NeighborhoodPositions lists positions of the blocks loaded into
+// neighborhoods of every block in centers, without duplicates
+func NeighborhoodPositions(centers []spatial.BlockPosition) []spatial.BlockPosition {
+	seen := make(map[spatial.BlockPosition]struct{}, len(centers))
+	var positions []spatial.BlockPosition
+	for _, center := range centers {
+		for z := -1; z <= 1; z++ {
+			for y := -1; y <= 1; y++ {
+				for x := -1; x <= 1; x++ {
+					pos := center.Add(spatial.BlockPosition{X: x, Y: y, Z: z})
+					if _, ok := seen[pos]; !ok {
+						seen[pos] = struct{}{}
+						positions = append(positions, pos)
+					}
+				}
+			}
+		}
+	}
+
+	return positions
+}
+
+// LoadNeighborhood fetches the block at center together with all 26 blocks
+// around it. Missing blocks leave their slots empty.
+func LoadNeighborhood(ctx context.Context, w *world.World, center spatial.BlockPosition) (*BlockNeighborhood, error) {
+	neighborhood := &BlockNeighborhood{}
+	if err := neighborhood.Load(ctx, w, center); err != nil {
+		return nil, err
+	}
+
+	return neighborhood, nil
+}
+
+// Load replaces contents of the neighborhood with the block at center and
+// the blocks around it, like LoadNeighborhood, without allocating a new one
+func (b *BlockNeighborhood) Load(ctx context.Context, w *world.World, center spatial.BlockPosition) error {
+	offsets := make([]spatial.BlockPosition, 0, 27)
+	positions := make([]spatial.BlockPosition, 0, 27)
+	for z := -1; z <= 1; z++ {
+		for y := -1; y <= 1; y++ {
+			for x := -1; x <= 1; x++ {
+				offset := spatial.BlockPosition{X: x, Y: y, Z: z}
+				offsets = append(offsets, offset)
+				positions = append(positions, center.Add(offset))
+			}
+		}
+	}
+
+	blocks, errs := w.GetBlocks(ctx, positions)
+
+	b.Clear()
+	for i, offset := range offsets {
+		if errors.Is(errs[i], world.ErrBlockNotFound) {
+			continue
+		}
+
+		// Undecodable blocks are left out like missing ones. Each block is
+		// the center of exactly one neighborhood per tile, so logging only
+		// centers reports it once.
+		if world.IsDecodeError(errs[i]) {
+			if offset == (spatial.BlockPosition{}) {
+				logging.Warnf("skipping block: %v", errs[i])
+			}
+			continue
+		}
+
+		if errs[i] != nil {
+			return errs[i]
+		}
+
+		b.SetBlock(neighborhoodCenter.Add(offset), blocks[i])
+	}
+
+	return nil
+}
+
+// SingleBlockNeighborhood returns a neighborhood with block in the center and
+// all other slots empty
+func SingleBlockNeighborhood(block *world.MapBlock) *BlockNeighborhood {
+	neighborhood := &BlockNeighborhood{}
+	neighborhood.SetBlock(neighborhoodCenter, block)
+	return neighborhood
+}
+
+// CenterBlock returns the block in the middle of the neighborhood, or nil if
+// it's missing
+func (b *BlockNeighborhood) CenterBlock() *world.MapBlock {
+	return b.blocks[blockIndex(neighborhoodCenter)]
 }
 
 func (b *BlockNeighborhood) SetBlock(pos spatial.BlockPosition, block *world.MapBlock) {
@@ -30,29 +146,42 @@ func (b *BlockNeighborhood) SetBlock(pos spatial.BlockPosition, block *world.Map
 }
 
 func (b *BlockNeighborhood) getBlockByNodePos(pos spatial.NodePosition) *world.MapBlock {
-	blockPos := spatial.BlockPosition{
-		X: pos.X/spatial.BlockSize + neighborhoodCenter.X,
-		Y: pos.Y/spatial.BlockSize + neighborhoodCenter.Y,
-		Z: pos.Z/spatial.BlockSize + neighborhoodCenter.Z,
-	}
+	blockPos := spatial.NodeToBlock(pos).Add(neighborhoodCenter)
 
 	return b.blocks[blockIndex(blockPos)]
 }
 
-func (b *BlockNeighborhood) GetNode(pos spatial.NodePosition) (string, uint8, uint8) {
+// ResolvedNode is a node with its content ID resolved to a name
+type ResolvedNode struct {
+	Name   string
+	Param1 uint8
+	Param2 uint8
+}
+
+// GetResolvedNode returns the node at pos. Nodes in empty slots are reported
+// as `ignore`.
+func (b *BlockNeighborhood) GetResolvedNode(pos spatial.NodePosition) ResolvedNode {
 	block := b.getBlockByNodePos(pos)
 
 	if block == nil {
-		return "ignore", 0, 0
+		return ResolvedNode{Name: "ignore"}
 	}
 
-	node := block.GetNode(spatial.NodePosition{
-		X: pos.X % spatial.BlockSize,
-		Y: pos.Y % spatial.BlockSize,
-		Z: pos.Z % spatial.BlockSize,
-	})
-	name := block.ResolveName(node.ID)
-	return name, node.Param1, node.Param2
+	node := block.GetNode(spatial.NodeInBlock(pos))
+	return ResolvedNode{
+		Name:   block.ResolveName(node.ID),
+		Param1: node.Param1,
+		Param2: node.Param2,
+	}
+}
+
+// GetNode returns name, param1 and param2 of the node at pos.
+//
+// Deprecated: use GetResolvedNode, which doesn't make it easy to mix up
+// param1 and param2.
+func (b *BlockNeighborhood) GetNode(pos spatial.NodePosition) (string, uint8, uint8) {
+	node := b.GetResolvedNode(pos)
+	return node.Name, node.Param1, node.Param2
 }
 
 func (b *BlockNeighborhood) GetParam1(pos spatial.NodePosition) uint8 {
@@ -62,11 +191,7 @@ func (b *BlockNeighborhood) GetParam1(pos spatial.NodePosition) uint8 {
 		return 0
 	}
 
-	node := block.GetNode(spatial.NodePosition{
-		X: pos.X % spatial.BlockSize,
-		Y: pos.Y % spatial.BlockSize,
-		Z: pos.Z % spatial.BlockSize,
-	})
+	node := block.GetNode(spatial.NodeInBlock(pos))
 
 	return node.Param1
 }
@@ -0,0 +1,114 @@
+package render
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/weqqr/panorama/pkg/spatial"
+	"github.com/weqqr/panorama/pkg/world"
+)
+
+// uniformBlock serializes a block made of nodes named name in the version 28
+// format
+func uniformBlock(name string) []byte {
+	compress := func(data []byte) []byte {
+		var buf bytes.Buffer
+		w := zlib.NewWriter(&buf)
+		w.Write(data)
+		w.Close()
+		return buf.Bytes()
+	}
+
+	var buf bytes.Buffer
+	buf.Write([]byte{28, 0, 0xff, 0xff, 2, 2})
+	buf.Write(compress(make([]byte, spatial.BlockVolume*world.NodeSizeInBytes)))
+	buf.Write(compress([]byte{0}))
+	// Static objects and timestamp
+	buf.Write([]byte{0, 0, 0, 0, 0, 0, 0})
+	// Name-id mapping
+	buf.WriteByte(0)
+	binary.Write(&buf, binary.BigEndian, []uint16{1, 0, uint16(len(name))})
+	buf.WriteString(name)
+	return buf.Bytes()
+}
+
+func TestNeighborhoodNegativePosition(t *testing.T) {
+	backend := world.NewMemoryBackend()
+	center := spatial.BlockPosition{X: -1, Y: -1, Z: -1}
+	backend.SetBlock(center, uniformBlock("center"))
+	backend.SetBlock(spatial.BlockPosition{X: -2, Y: -1, Z: -1}, uniformBlock("west"))
+	backend.SetBlock(spatial.BlockPosition{X: -1, Y: -2, Z: -2}, uniformBlock("below"))
+	w := world.NewWorldWithBackend(backend)
+
+	neighborhood, err := LoadNeighborhood(context.Background(), &w, center)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		pos  spatial.NodePosition
+		name string
+	}{
+		{spatial.NodePosition{X: 0, Y: 0, Z: 0}, "center"},
+		{spatial.NodePosition{X: 15, Y: 15, Z: 15}, "center"},
+		{spatial.NodePosition{X: -1, Y: 0, Z: 0}, "west"},
+		{spatial.NodePosition{X: -16, Y: 15, Z: 15}, "west"},
+		{spatial.NodePosition{X: 0, Y: -1, Z: -1}, "below"},
+		{spatial.NodePosition{X: 15, Y: -16, Z: -16}, "below"},
+		{spatial.NodePosition{X: 0, Y: -1, Z: 0}, "ignore"},
+		{spatial.NodePosition{X: 16, Y: 0, Z: 0}, "ignore"},
+	} {
+		if got := neighborhood.GetResolvedNode(test.pos).Name; got != test.name {
+			t.Errorf("node at %v is %v, want %v", test.pos, got, test.name)
+		}
+	}
+}
+
+func TestNeighborhoodSlots(t *testing.T) {
+	var neighborhood BlockNeighborhood
+	blocks := map[spatial.BlockPosition]*world.MapBlock{}
+	for z := 0; z < 3; z++ {
+		for y := 0; y < 3; y++ {
+			for x := 0; x < 3; x++ {
+				pos := spatial.BlockPosition{X: x, Y: y, Z: z}
+				blocks[pos] = &world.MapBlock{}
+				neighborhood.SetBlock(pos, blocks[pos])
+			}
+		}
+	}
+
+	for pos, block := range blocks {
+		// Any node inside the block must be read from its slot
+		for _, offset := range []spatial.NodePosition{{X: 0, Y: 0, Z: 0}, {X: 15, Y: 15, Z: 15}, {X: 3, Y: 9, Z: 14}} {
+			nodePos := pos.Sub(neighborhoodCenter).AddNode(offset)
+			if got := neighborhood.getBlockByNodePos(nodePos); got != block {
+				t.Errorf("node at %v isn't read from block %v", nodePos, pos)
+			}
+		}
+	}
+}
+
+func TestNeighborhoodPositions(t *testing.T) {
+	centers := []spatial.BlockPosition{{X: 0, Y: 0, Z: 0}, {X: 1, Y: 0, Z: 0}, {X: 0, Y: 0, Z: 0}}
+	positions := NeighborhoodPositions(centers)
+
+	// Two neighboring blocks share 18 of their 27 neighborhood blocks
+	if len(positions) != 36 {
+		t.Errorf("got %v positions, want 36", len(positions))
+	}
+
+	seen := map[spatial.BlockPosition]bool{}
+	for _, pos := range positions {
+		if seen[pos] {
+			t.Errorf("%v is listed twice", pos)
+		}
+		seen[pos] = true
+
+		if pos.X < -1 || pos.X > 2 || pos.Y < -1 || pos.Y > 1 || pos.Z < -1 || pos.Z > 1 {
+			t.Errorf("%v is outside the neighborhoods", pos)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package render
+
+import "image/color"
+
+// FaceShading holds brightness multipliers of node faces depending on the
+// direction they face. Left and right refer to the side faces visible on the
+// screen.
+type FaceShading struct {
+	Top   float64
+	Left  float64
+	Right float64
+}
+
+// NodeColorFunc returns the color a node is drawn with instead of its
+// textures. If the second value is false, the node is drawn as usual.
+type NodeColorFunc func(name string, param1, param2 uint8) (color.NRGBA, bool)
+
+// Options tweak the look of rendered nodes
+type Options struct {
+	// AmbientOcclusion is how much a fully occluded corner is darkened, from 0
+	// (no ambient occlusion) to 1 (black)
+	AmbientOcclusion float64
+	FaceShading      FaceShading
+	// FullBright draws all nodes fully lit instead of shading them by their
+	// day light level
+	FullBright bool
+	// LightGamma selects the curve mapping light levels to brightness, see
+	// LightBrightness
+	LightGamma float64
+	// NodeColor, if set, is consulted for every node except air before its
+	// textures. Nodes it returns a color for are drawn as cubes of that
+	// color, which can be used for heatmaps or debugging overlays.
+	NodeColor NodeColorFunc
+}
+
+// DefaultOptions returns options that don't change the look of nodes
+func DefaultOptions() Options {
+	return Options{
+		AmbientOcclusion: 0,
+		FaceShading: FaceShading{
+			Top:   1,
+			Left:  1,
+			Right: 1,
+		},
+	}
+}
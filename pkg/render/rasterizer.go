@@ -14,24 +14,73 @@ import (
 const Gamma = 2.2
 const BaseResolution = 16
 
+// FaceOcclusion stores ambient occlusion levels (0-3) of the four corners of
+// a cube face, two bits per corner. Corners are ordered by their texture
+// coordinates: (0, 0), (1, 0), (0, 1), (1, 1).
+type FaceOcclusion uint8
+
+// MaxOcclusion is the occlusion level of a corner surrounded by solid nodes
+const MaxOcclusion = 3
+
+func (o FaceOcclusion) Corner(i int) int {
+	return int(o>>(2*i)) & MaxOcclusion
+}
+
+func (o FaceOcclusion) WithCorner(i int, level int) FaceOcclusion {
+	return o&^(MaxOcclusion<<(2*i)) | FaceOcclusion(level&MaxOcclusion)<<(2*i)
+}
+
+// FaceEdges is a set of edges of a cube face, named after the texture
+// coordinate along them
+type FaceEdges uint8
+
+const (
+	FaceEdgeV0 FaceEdges = 1 << iota
+	FaceEdgeV1
+	FaceEdgeU0
+	FaceEdgeU1
+)
+
 type RenderableNode struct {
 	Name        string
 	Light       float64
 	Param2      uint8
 	HiddenFaces mesh.CubeFaces
+	// Occlusion of cube faces, in the same order as meshes returned by
+	// mesh.Cube. It's ignored for nodes that aren't cubes.
+	Occlusion [6]FaceOcclusion
+	// LiquidHeights are heights of the top corners of liquids in
+	// LiquidHeightUnits, ordered as in mesh.LiquidCube. If all of them are 0,
+	// the liquid fills the entire node.
+	LiquidHeights [4]uint8
+	// ConnectedEdges lists edges of faces of framed glass which continue into
+	// the same glass, in the same order as meshes returned by mesh.Cube.
+	// Frames aren't drawn along them.
+	ConnectedEdges [6]FaceEdges
+	// Color is the color returned by Options.NodeColor for the node, which
+	// replaces its textures. It tells apart nodes of the same name drawn
+	// with different colors.
+	Color color.NRGBA
 }
 
+// LiquidHeightUnits is the number of steps liquid heights are divided into
+const LiquidHeightUnits = 16
+
 type NodeRasterizer struct {
 	cache map[RenderableNode]*raster.RenderBuffer
 
 	projection lm.Matrix3
+
+	options Options
 }
 
-func NewNodeRasterizer(projection lm.Matrix3) NodeRasterizer {
+func NewNodeRasterizer(projection lm.Matrix3, options Options) NodeRasterizer {
 	return NodeRasterizer{
 		cache: make(map[RenderableNode]*raster.RenderBuffer),
 
 		projection: projection,
+
+		options: options,
 	}
 }
 
@@ -72,7 +121,34 @@ func sampleTexture(tex *image.NRGBA, texcoord lm.Vector2) lm.Vector4 {
 var SunLightDir = lm.Vec3(-0.5, 1, -0.8).Normalize()
 var SunLightIntensity = 0.95 / SunLightDir.MaxComponent()
 
-func (r *NodeRasterizer) drawTriangle(target *raster.RenderBuffer, tex *image.NRGBA, lighting float64, a, b, c mesh.Vertex) {
+// occlusionAt interpolates darkening of face corners at texcoord
+func occlusionAt(corners [4]float64, texcoord lm.Vector2) float64 {
+	u := lm.Clamp(texcoord.X, 0, 1)
+	v := lm.Clamp(texcoord.Y, 0, 1)
+
+	top := corners[0]*(1-u) + corners[1]*u
+	bottom := corners[2]*(1-u) + corners[3]*u
+
+	return top*(1-v) + bottom*v
+}
+
+// faceShading returns brightness of a face with the given normal. Faces that
+// aren't aligned with the axes blend the multipliers of the closest ones.
+func (r *NodeRasterizer) faceShading(normal lm.Vector3) float64 {
+	shading := r.options.FaceShading
+
+	lengthSquared := normal.Dot(normal)
+	if lengthSquared == 0 {
+		return 1
+	}
+
+	// Squared components of a unit vector sum up to 1. East faces (+X) are on
+	// the left side of the screen, north faces (+Z) are on the right.
+	weighted := normal.X*normal.X*shading.Left + normal.Y*normal.Y*shading.Top + normal.Z*normal.Z*shading.Right
+	return weighted / lengthSquared
+}
+
+func (r *NodeRasterizer) drawTriangle(target *raster.RenderBuffer, tex *image.NRGBA, tint lm.Vector3, alphaMode game.AlphaMode, lighting float64, occlusion [4]float64, a, b, c mesh.Vertex) {
 	origin := lm.Vector2{
 		X: float64(target.Color.Bounds().Dx()) / 2,
 		Y: float64(target.Color.Bounds().Dy()) / 2,
@@ -103,15 +179,29 @@ func (r *NodeRasterizer) drawTriangle(target *raster.RenderBuffer, tex *image.NR
 				Add(b.Normal.MulScalar(barycentric.Y)).
 				Add(c.Normal.MulScalar(barycentric.Z))
 
+			texcoord := a.Texcoord.MulScalar(barycentric.X).
+				Add(b.Texcoord.MulScalar(barycentric.Y)).
+				Add(c.Texcoord.MulScalar(barycentric.Z))
+
 			lighting := SunLightIntensity * lighting * lm.Clamp(math.Abs(normal.Dot(SunLightDir))*0.8+0.2, 0.0, 1.0)
+			lighting *= r.faceShading(normal) * (1 - occlusionAt(occlusion, texcoord))
 
 			var finalColor color.NRGBA
 			if tex != nil {
-				texcoord := a.Texcoord.MulScalar(barycentric.X).
-					Add(b.Texcoord.MulScalar(barycentric.Y)).
-					Add(c.Texcoord.MulScalar(barycentric.Z))
 				rgba := sampleTexture(tex, texcoord)
-				col := rgba.XYZ().PowScalar(Gamma).MulScalar(lighting).PowScalar(1.0/Gamma).ClampScalar(0.0, 1.0)
+
+				switch alphaMode {
+				case game.AlphaModeOpaque:
+					rgba.W = 1
+				case game.AlphaModeClip:
+					// Alpha is a binary mask
+					if rgba.W < 0.5 {
+						continue
+					}
+					rgba.W = 1
+				}
+
+				col := lm.Vec3(rgba.X*tint.X, rgba.Y*tint.Y, rgba.Z*tint.Z).PowScalar(Gamma).MulScalar(lighting).PowScalar(1.0/Gamma).ClampScalar(0.0, 1.0)
 
 				finalColor = color.NRGBA{
 					R: uint8(255 * col.X),
@@ -140,48 +230,65 @@ func (r *NodeRasterizer) drawTriangle(target *raster.RenderBuffer, tex *image.NR
 	}
 }
 
-func transformToFaceDir(v lm.Vector3, facedir uint8) lm.Vector3 {
-	axis := (facedir >> 2) & 0x7
-	dir := facedir & 0x3
-
-	// Left click with screwdriver
-	switch dir {
-	case 0: // no-op
-	case 1:
-		v = v.RotateXZ(lm.Radians(-90))
-	case 2:
-		v = v.RotateXZ(lm.Radians(180))
-	case 3:
-		v = v.RotateXZ(lm.Radians(90))
-	}
-
-	// Right click with screwdriver
-	switch axis {
-	case 0: // no-op
-	case 1:
-		v = v.RotateYZ(lm.Radians(90))
-	case 2:
-		v = v.RotateYZ(lm.Radians(-90))
-	case 3:
-		v = v.RotateXY(lm.Radians(-90))
-	case 4:
-		v = v.RotateXY(lm.Radians(90))
-	case 5:
-		v = v.RotateXY(lm.Radians(180))
-	}
-
-	return v
-}
-
 func (r *NodeRasterizer) createMesh(node RenderableNode, nodeDef *game.NodeDefinition) *mesh.Model {
 	switch {
+	case nodeDef.DrawType.IsLiquid() && node.LiquidHeights != [4]uint8{}:
+		var heights [4]float64
+		for i, height := range node.LiquidHeights {
+			heights[i] = float64(height)/LiquidHeightUnits - 0.5
+		}
+
+		return mesh.LiquidCube(node.HiddenFaces, heights)
 	case nodeDef.DrawType.IsLiquid():
 		return mesh.Cube(node.HiddenFaces)
+	case (nodeDef.DrawType == game.DrawTypeNormal || nodeDef.DrawType.IsGlasslike()) && node.HiddenFaces != mesh.CubeFaceNone:
+		return mesh.Cube(node.HiddenFaces)
+	case nodeDef.DrawType == game.DrawTypeNodeBox:
+		return nodeDef.NodeBoxModel(node.Param2)
 	default:
 		return nodeDef.Model
 	}
 }
 
+// removeFrame returns a copy of tex with border pixels along edges cleared.
+// Borders of edges that remain are kept intact, including the corners.
+func removeFrame(tex *image.NRGBA, edges FaceEdges) *image.NRGBA {
+	if tex == nil || edges == 0 {
+		return tex
+	}
+
+	bounds := tex.Rect
+	width := bounds.Dx() / BaseResolution
+	if width < 1 {
+		width = 1
+	}
+
+	inFrame := func(pos int, size int) (start, end bool) {
+		return pos < width, pos >= size-width
+	}
+
+	result := image.NewNRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			left, right := inFrame(x-bounds.Min.X, bounds.Dx())
+			top, bottom := inFrame(y-bounds.Min.Y, bounds.Dy())
+
+			keptVertical := (left && edges&FaceEdgeU0 == 0) || (right && edges&FaceEdgeU1 == 0)
+			keptHorizontal := (top && edges&FaceEdgeV0 == 0) || (bottom && edges&FaceEdgeV1 == 0)
+			removed := (left && edges&FaceEdgeU0 != 0) || (right && edges&FaceEdgeU1 != 0) ||
+				(top && edges&FaceEdgeV0 != 0) || (bottom && edges&FaceEdgeV1 != 0)
+
+			if removed && !keptVertical && !keptHorizontal {
+				continue
+			}
+
+			result.SetNRGBA(x, y, tex.NRGBAAt(x, y))
+		}
+	}
+
+	return result
+}
+
 func (r *NodeRasterizer) Render(node RenderableNode, nodeDef *game.NodeDefinition) *raster.RenderBuffer {
 	if nodeDef.DrawType == game.DrawTypeAirlike || nodeDef.Model == nil || len(nodeDef.Textures) == 0 {
 		return nil
@@ -196,21 +303,44 @@ func (r *NodeRasterizer) Render(node RenderableNode, nodeDef *game.NodeDefinitio
 
 	model := r.createMesh(node, nodeDef)
 
+	// Rotating the geometry also moves tiles to the faces they end up on
+	facedir := nodeDef.FaceDir(node.Param2)
+	alphaMode := nodeDef.EffectiveAlphaMode()
+	rotation := FaceDirRotation(facedir)
+
+	// Textures of colored nodes are multiplied with their color
+	tint := lm.Vec3(1, 1, 1)
+	if c, ok := nodeDef.Tint(node.Param2); ok {
+		tint = lm.Vec3(float64(c.R)/255, float64(c.G)/255, float64(c.B)/255)
+	}
+
 	for j, mesh := range model.Meshes {
 		triangleCount := len(mesh.Vertices) / 3
 
+		texture := nodeDef.Textures[j]
+		if j < len(node.ConnectedEdges) {
+			texture = removeFrame(texture, node.ConnectedEdges[j])
+		}
+
+		var occlusion [4]float64
+		if j < len(node.Occlusion) {
+			for corner := range occlusion {
+				occlusion[corner] = r.options.AmbientOcclusion * float64(node.Occlusion[j].Corner(corner)) / MaxOcclusion
+			}
+		}
+
 		for i := 0; i < triangleCount; i++ {
 			a := mesh.Vertices[i*3]
 			b := mesh.Vertices[i*3+1]
 			c := mesh.Vertices[i*3+2]
 
-			if nodeDef.ParamType2 == game.ParamType2FaceDir {
-				a.Position = transformToFaceDir(a.Position, node.Param2)
-				b.Position = transformToFaceDir(b.Position, node.Param2)
-				c.Position = transformToFaceDir(c.Position, node.Param2)
-				a.Normal = transformToFaceDir(a.Normal, node.Param2)
-				b.Normal = transformToFaceDir(b.Normal, node.Param2)
-				c.Normal = transformToFaceDir(c.Normal, node.Param2)
+			if facedir != 0 {
+				a.Position = rotation.MulVec(a.Position)
+				b.Position = rotation.MulVec(b.Position)
+				c.Position = rotation.MulVec(c.Position)
+				a.Normal = rotation.MulVec(a.Normal)
+				b.Normal = rotation.MulVec(b.Normal)
+				c.Normal = rotation.MulVec(c.Normal)
 			}
 
 			a.Position.Z = -a.Position.Z
@@ -221,7 +351,7 @@ func (r *NodeRasterizer) Render(node RenderableNode, nodeDef *game.NodeDefinitio
 			b.Position.X = -b.Position.X
 			c.Position.X = -c.Position.X
 
-			r.drawTriangle(target, nodeDef.Textures[j], node.Light, a, b, c)
+			r.drawTriangle(target, texture, tint, alphaMode, node.Light, occlusion, a, b, c)
 		}
 	}
 
@@ -0,0 +1,34 @@
+package render
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/weqqr/panorama/pkg/game"
+	"github.com/weqqr/panorama/pkg/lm"
+)
+
+func TestRenderTintedNode(t *testing.T) {
+	nr := NewNodeRasterizer(lm.DimetricProjection(), DefaultOptions())
+
+	nodeDef := game.ColorNode(color.NRGBA{255, 255, 255, 255})
+	nodeDef.Color = &color.NRGBA{255, 0, 0, 255}
+	buffer := nr.Render(RenderableNode{Name: "tinted", Light: 1}, &nodeDef)
+
+	drawn := 0
+	for i := 0; i < len(buffer.Color.Pix); i += 4 {
+		pixel := buffer.Color.Pix[i : i+4]
+		if pixel[3] == 0 {
+			continue
+		}
+
+		drawn++
+		if pixel[0] == 0 || pixel[1] != 0 || pixel[2] != 0 {
+			t.Fatalf("pixel %v of a white node tinted red isn't red", pixel)
+		}
+	}
+
+	if drawn == 0 {
+		t.Error("nothing was drawn")
+	}
+}
@@ -1,8 +1,11 @@
 package render
 
 import (
+	"context"
+
 	"github.com/weqqr/panorama/pkg/game"
 	"github.com/weqqr/panorama/pkg/raster"
+	"github.com/weqqr/panorama/pkg/spatial"
 	"github.com/weqqr/panorama/pkg/world"
 )
 
@@ -11,7 +14,15 @@ type TilePosition struct {
 }
 
 type Renderer interface {
-	RenderTile(pos TilePosition, w *world.World, game *game.Game) *raster.RenderBuffer
+	// RenderTile draws the tile at pos. If blocks of the tile can't be
+	// fetched, it returns an error and the tile must not be saved.
+	RenderTile(ctx context.Context, pos TilePosition, w *world.World, game *game.Game) (*raster.RenderBuffer, error)
+	// TileBlocks lists positions of blocks drawn on the tile at pos
+	TileBlocks(pos TilePosition) []spatial.BlockPosition
+	// ReadBlocks lists positions of all blocks RenderTile reads for the tile
+	// at pos, which includes blocks around drawn ones if their nodes affect
+	// how the tile looks
+	ReadBlocks(pos TilePosition) []spatial.BlockPosition
 	// ListTilesWithBlock(x, y, z int) []TilePosition
 	// ListTilesInsideRegion(region config.Region) []TilePosition
 }
@@ -0,0 +1,72 @@
+package topdown
+
+import (
+	"image/color"
+
+	"github.com/weqqr/panorama/pkg/lm"
+	"github.com/weqqr/panorama/pkg/raster"
+	"github.com/weqqr/panorama/pkg/spatial"
+)
+
+// NewHeightmapRenderer creates a renderer drawing the height of the topmost
+// node of every column instead of its color. Every node except air counts,
+// including liquids and plants. Heights from the bottom to the top of region
+// are drawn from black to white, or with colormap from blue through green to
+// white.
+func NewHeightmapRenderer(region spatial.Region, colormap bool) *Renderer {
+	r := NewRenderer(region, nil, color.NRGBA{A: 255}, false, nil)
+	r.heightmap = true
+	r.colormap = colormap
+
+	return r
+}
+
+// heightmapStops are colors of the colormap at evenly spaced heights
+var heightmapStops = []color.NRGBA{
+	{R: 0, G: 0, B: 160, A: 255},
+	{R: 0, G: 160, B: 0, A: 255},
+	{R: 255, G: 255, B: 255, A: 255},
+}
+
+// heightColor returns the color of a node at height between 0 (the bottom of
+// the region) and 1 (the top)
+func heightColor(height float64, colormap bool) color.NRGBA {
+	if !colormap {
+		value := uint8(lm.Clamp(height*255, 0, 255))
+		return color.NRGBA{R: value, G: value, B: value, A: 255}
+	}
+
+	position := lm.Clamp(height, 0, 1) * float64(len(heightmapStops)-1)
+	i := int(position)
+	if i == len(heightmapStops)-1 {
+		return heightmapStops[i]
+	}
+
+	t := position - float64(i)
+	from, to := heightmapStops[i], heightmapStops[i+1]
+	mix := func(a, b uint8) uint8 {
+		return uint8(float64(a) + (float64(b)-float64(a))*t)
+	}
+
+	return color.NRGBA{R: mix(from.R, to.R), G: mix(from.G, to.G), B: mix(from.B, to.B), A: 255}
+}
+
+// drawHeights replaces colors of drawn pixels with colors of their heights
+func (r *Renderer) drawHeights(target *raster.RenderBuffer, heights *heightMap) {
+	bottom := float64(r.region.YBounds.Min)
+	span := float64(r.region.YBounds.Max - r.region.YBounds.Min)
+	if span == 0 {
+		span = 1
+	}
+
+	for y := 0; y < TileSize; y++ {
+		for x := 0; x < TileSize; x++ {
+			height := heights[y][x]
+			if height == noHeight {
+				continue
+			}
+
+			target.Color.SetNRGBA(x, y, heightColor((float64(height)-bottom)/span, r.colormap))
+		}
+	}
+}
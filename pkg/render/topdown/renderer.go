@@ -0,0 +1,288 @@
+package topdown
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/weqqr/panorama/pkg/game"
+	"github.com/weqqr/panorama/pkg/lm"
+	"github.com/weqqr/panorama/pkg/logging"
+	"github.com/weqqr/panorama/pkg/raster"
+	"github.com/weqqr/panorama/pkg/render"
+	"github.com/weqqr/panorama/pkg/spatial"
+	"github.com/weqqr/panorama/pkg/world"
+)
+
+// TileSize is the width and height of a tile in nodes. Every node is drawn
+// as a single pixel.
+const TileSize = 256
+
+const tileBlocks = TileSize / spatial.BlockSize
+
+// Renderer draws the map as seen from above, coloring each pixel by the
+// topmost visible node in the column, like minetestmapper
+type Renderer struct {
+	region        spatial.Region
+	colors        map[string]color.NRGBA
+	defaultColor  color.NRGBA
+	heightShading bool
+	nodeColorFunc render.NodeColorFunc
+	// heightmap and colormap are set by NewHeightmapRenderer
+	heightmap bool
+	colormap  bool
+
+	// target and heights are reused between tiles, so a renderer must not be
+	// shared by several goroutines
+	target  *raster.RenderBuffer
+	heights *heightMap
+}
+
+// NewRenderer creates a renderer that takes node colors from colors. Nodes
+// that don't have a color use defaultColor. If heightShading is set, slopes
+// facing north-west are lightened and the ones facing south-east are
+// darkened, so that terrain relief is visible. nodeColor may be nil, see
+// render.Options.NodeColor. Nodes it colors are drawn opaque, except that a
+// fully transparent color makes them invisible.
+func NewRenderer(region spatial.Region, colors map[string]color.NRGBA, defaultColor color.NRGBA, heightShading bool, nodeColor render.NodeColorFunc) *Renderer {
+	return &Renderer{
+		region:        region,
+		colors:        colors,
+		defaultColor:  defaultColor,
+		heightShading: heightShading,
+		nodeColorFunc: nodeColor,
+		target:        raster.NewRenderBuffer(image.Rect(0, 0, TileSize, TileSize)),
+		heights:       &heightMap{},
+	}
+}
+
+// noHeight marks pixels that have no node drawn
+const noHeight = math.MinInt32
+
+// heightMap stores Y coordinate of the drawn node for every pixel of a tile
+type heightMap [TileSize][TileSize]int
+
+// shade adjusts brightness of every pixel according to the difference in
+// height with its western and northern neighbors, similarly to minetestmapper
+func shade(target *raster.RenderBuffer, heights *heightMap) {
+	const maxShade = 36
+
+	for y := 0; y < TileSize; y++ {
+		for x := 0; x < TileSize; x++ {
+			height := heights[y][x]
+			if height == noHeight {
+				continue
+			}
+
+			slope := 0
+			if x > 0 && heights[y][x-1] != noHeight {
+				slope += height - heights[y][x-1]
+			}
+			if y > 0 && heights[y-1][x] != noHeight {
+				slope += height - heights[y-1][x]
+			}
+
+			delta := int(lm.Clamp(float64(slope*12), -maxShade, maxShade))
+			if delta == 0 {
+				continue
+			}
+
+			adjust := func(component uint8) uint8 {
+				return uint8(lm.Clamp(float64(int(component)+delta), 0, 255))
+			}
+
+			c := target.Color.NRGBAAt(x, y)
+			c.R, c.G, c.B = adjust(c.R), adjust(c.G), adjust(c.B)
+			target.Color.SetNRGBA(x, y, c)
+		}
+	}
+}
+
+// nodeColor returns the color of the named node. The second value is false
+// for nodes that can be seen through.
+func (r *Renderer) nodeColor(name string, param1, param2 uint8) (color.NRGBA, bool) {
+	if name == "air" || name == "ignore" {
+		return color.NRGBA{}, false
+	}
+
+	if r.nodeColorFunc != nil {
+		if c, ok := r.nodeColorFunc(name, param1, param2); ok {
+			if c.A == 0 {
+				return c, false
+			}
+
+			c.A = 255
+			return c, true
+		}
+	}
+
+	if c, ok := r.colors[name]; ok {
+		c.A = 255
+		return c, true
+	}
+
+	return r.defaultColor, true
+}
+
+// renderColumn draws a single 16x16 column of blocks and stores the height of
+// drawn nodes in heights. filled marks pixels that already have the topmost
+// node drawn.
+func (r *Renderer) renderColumn(ctx context.Context, target *raster.RenderBuffer, heights *heightMap, w *world.World, column spatial.BlockPosition, origin image.Point) error {
+	var filled [spatial.BlockSize][spatial.BlockSize]bool
+	remaining := spatial.BlockSize * spatial.BlockSize
+
+	yMax := lm.FloorDiv(r.region.YBounds.Max, spatial.BlockSize)
+	yMin := lm.FloorDiv(r.region.YBounds.Min, spatial.BlockSize)
+
+	for y := yMax; y >= yMin && remaining > 0; y-- {
+		blockPos := spatial.BlockPosition{X: column.X, Y: y, Z: column.Z}
+		if !r.region.Intersects(blockPos.Region()) {
+			continue
+		}
+
+		block, err := w.GetBlock(ctx, blockPos)
+		if errors.Is(err, world.ErrBlockNotFound) {
+			continue
+		}
+
+		// Only the broken block is left out, nodes below it are drawn
+		// instead
+		if world.IsDecodeError(err) {
+			logging.Warnf("skipping block: %v", err)
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if block.IsAir() {
+			continue
+		}
+
+		for z := 0; z < spatial.BlockSize; z++ {
+			for x := 0; x < spatial.BlockSize; x++ {
+				if filled[z][x] {
+					continue
+				}
+
+				for nodeY := spatial.BlockSize - 1; nodeY >= 0; nodeY-- {
+					nodePos := spatial.NodePosition{X: x, Y: nodeY, Z: z}
+					if !r.region.Intersects(blockPos.AddNode(nodePos).Region()) {
+						continue
+					}
+
+					node := block.GetNode(nodePos)
+					c, visible := r.nodeColor(block.ResolveName(node.ID), node.Param1, node.Param2)
+					if !visible {
+						continue
+					}
+
+					// North (+Z) is at the top of the image
+					pixelX, pixelY := origin.X+x, origin.Y+spatial.BlockSize-1-z
+					target.Color.SetNRGBA(pixelX, pixelY, c)
+					target.Dirty = true
+					heights[pixelY][pixelX] = blockPos.Y*spatial.BlockSize + nodeY
+
+					filled[z][x] = true
+					remaining--
+					break
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// RenderTile draws the tile at tilePos. The returned buffer is reused by the
+// next call.
+func (r *Renderer) RenderTile(
+	ctx context.Context,
+	tilePos render.TilePosition,
+	w *world.World,
+	game *game.Game,
+) (*raster.RenderBuffer, error) {
+	target := r.target
+	target.Clear()
+
+	heights := r.heights
+	for y := range heights {
+		for x := range heights[y] {
+			heights[y][x] = noHeight
+		}
+	}
+
+	for z := 0; z < tileBlocks; z++ {
+		for x := 0; x < tileBlocks; x++ {
+			// Tile rows go from north to south
+			column := spatial.BlockPosition{
+				X: tilePos.X*tileBlocks + x,
+				Z: -tilePos.Y*tileBlocks - z - 1,
+			}
+
+			origin := image.Pt(x*spatial.BlockSize, z*spatial.BlockSize)
+
+			err := r.renderColumn(ctx, target, heights, w, column, origin)
+			if err != nil {
+				return nil, fmt.Errorf("rendering block column %v: %w", column, err)
+			}
+		}
+	}
+
+	if r.heightmap {
+		r.drawHeights(target, heights)
+	} else if r.heightShading {
+		shade(target, heights)
+	}
+
+	return target, nil
+}
+
+// TileBlocks lists positions of blocks drawn on the tile at tilePos
+func (r *Renderer) TileBlocks(tilePos render.TilePosition) []spatial.BlockPosition {
+	yMax := lm.FloorDiv(r.region.YBounds.Max, spatial.BlockSize)
+	yMin := lm.FloorDiv(r.region.YBounds.Min, spatial.BlockSize)
+
+	var positions []spatial.BlockPosition
+	for z := 0; z < tileBlocks; z++ {
+		for x := 0; x < tileBlocks; x++ {
+			for y := yMax; y >= yMin; y-- {
+				blockPos := spatial.BlockPosition{
+					X: tilePos.X*tileBlocks + x,
+					Y: y,
+					Z: -tilePos.Y*tileBlocks - z - 1,
+				}
+
+				if r.region.Intersects(blockPos.Region()) {
+					positions = append(positions, blockPos)
+				}
+			}
+		}
+	}
+
+	return positions
+}
+
+// ReadBlocks lists the same blocks as TileBlocks, since columns are drawn
+// without looking at neighboring blocks
+func (r *Renderer) ReadBlocks(tilePos render.TilePosition) []spatial.BlockPosition {
+	return r.TileBlocks(tilePos)
+}
+
+// ProjectRegion returns the range of tiles covering region
+func ProjectRegion(region spatial.Region) spatial.TileRegion {
+	return spatial.TileRegion{
+		XBounds: spatial.Bounds{
+			Min: lm.FloorDiv(region.XBounds.Min, TileSize),
+			Max: lm.FloorDiv(region.XBounds.Max, TileSize) + 1,
+		},
+		YBounds: spatial.Bounds{
+			Min: lm.FloorDiv(-region.ZBounds.Max-1, TileSize),
+			Max: lm.FloorDiv(-region.ZBounds.Min-1, TileSize) + 1,
+		},
+	}
+}
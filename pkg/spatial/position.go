@@ -1,5 +1,13 @@
 package spatial
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/weqqr/panorama/pkg/lm"
+)
+
 const BlockSize = 16
 const BlockVolume = BlockSize * BlockSize * BlockSize
 
@@ -8,6 +16,22 @@ type NodePosition struct {
 	X, Y, Z int
 }
 
+// String formats the position as `(x,y,z)`
+func (lhs NodePosition) String() string {
+	return formatPosition(lhs.X, lhs.Y, lhs.Z)
+}
+
+// ParseNodePosition parses a node position written as `(x,y,z)`. The
+// parentheses are optional.
+func ParseNodePosition(position string) (NodePosition, error) {
+	coords, ok := parsePosition(position)
+	if !ok {
+		return NodePosition{}, fmt.Errorf("invalid node position: `%v`", position)
+	}
+
+	return NodePosition{X: coords[0], Y: coords[1], Z: coords[2]}, nil
+}
+
 func (lhs NodePosition) Region() Region {
 	return Region{
 		XBounds: Bounds{Min: lhs.X, Max: lhs.X},
@@ -24,11 +48,45 @@ func (lhs NodePosition) Add(rhs NodePosition) NodePosition {
 	}
 }
 
+func (lhs NodePosition) Sub(rhs NodePosition) NodePosition {
+	return NodePosition{
+		X: lhs.X - rhs.X,
+		Y: lhs.Y - rhs.Y,
+		Z: lhs.Z - rhs.Z,
+	}
+}
+
 // BlockPosition is a block position in world space
 type BlockPosition struct {
 	X, Y, Z int
 }
 
+// String formats the position as `(x,y,z)`
+func (lhs BlockPosition) String() string {
+	return formatPosition(lhs.X, lhs.Y, lhs.Z)
+}
+
+// ParseBlockPosition parses a block position written as `(x,y,z)`. The
+// parentheses are optional.
+func ParseBlockPosition(position string) (BlockPosition, error) {
+	coords, ok := parsePosition(position)
+	if !ok {
+		return BlockPosition{}, fmt.Errorf("invalid block position: `%v`", position)
+	}
+
+	return BlockPosition{X: coords[0], Y: coords[1], Z: coords[2]}, nil
+}
+
+// Region returns the nodes of the block
+func (lhs BlockPosition) Region() Region {
+	min := lhs.AddNode(NodePosition{})
+	return Region{
+		XBounds: Bounds{Min: min.X, Max: min.X + BlockSize - 1},
+		YBounds: Bounds{Min: min.Y, Max: min.Y + BlockSize - 1},
+		ZBounds: Bounds{Min: min.Z, Max: min.Z + BlockSize - 1},
+	}
+}
+
 func (lhs BlockPosition) AddNode(pos NodePosition) NodePosition {
 	return NodePosition{
 		X: lhs.X*BlockSize + pos.X,
@@ -44,3 +102,104 @@ func (lhs BlockPosition) Add(rhs BlockPosition) BlockPosition {
 		Z: lhs.Z + rhs.Z,
 	}
 }
+
+func (lhs BlockPosition) Sub(rhs BlockPosition) BlockPosition {
+	return BlockPosition{
+		X: lhs.X - rhs.X,
+		Y: lhs.Y - rhs.Y,
+		Z: lhs.Z - rhs.Z,
+	}
+}
+
+// Scale multiplies every coordinate by factor
+func (lhs BlockPosition) Scale(factor int) BlockPosition {
+	return BlockPosition{
+		X: lhs.X * factor,
+		Y: lhs.Y * factor,
+		Z: lhs.Z * factor,
+	}
+}
+
+// NodeToBlock returns the position of the block containing the node.
+// Coordinates are rounded down, so node -1 belongs to block -1.
+func NodeToBlock(pos NodePosition) BlockPosition {
+	return BlockPosition{
+		X: lm.FloorDiv(pos.X, BlockSize),
+		Y: lm.FloorDiv(pos.Y, BlockSize),
+		Z: lm.FloorDiv(pos.Z, BlockSize),
+	}
+}
+
+// NodeInBlock returns the position of the node relative to the block
+// containing it. Every coordinate is in [0, BlockSize), so that
+// NodeToBlock(pos).AddNode(NodeInBlock(pos)) == pos.
+func NodeInBlock(pos NodePosition) NodePosition {
+	return NodePosition{
+		X: lm.FloorMod(pos.X, BlockSize),
+		Y: lm.FloorMod(pos.Y, BlockSize),
+		Z: lm.FloorMod(pos.Z, BlockSize),
+	}
+}
+
+// BlockPosToInteger encodes block position into a single integer the same way
+// Minetest does for its key-value backends. Each component occupies 12 bits;
+// negative components borrow from the next one, which is why the result is a
+// plain sum rather than a bitwise OR.
+func BlockPosToInteger(pos BlockPosition) int64 {
+	return int64(pos.Z)*0x1000000 + int64(pos.Y)*0x1000 + int64(pos.X)
+}
+
+// IntegerToBlockPos is the inverse of BlockPosToInteger. Components outside
+// [-2048, 2047] wrap around, as they do in Minetest.
+func IntegerToBlockPos(i int64) BlockPosition {
+	unsignedToSigned := func(i int64) int {
+		if i < 2048 {
+			return int(i)
+		}
+		return int(i - 4096)
+	}
+
+	// Python-style modulo, the result is always positive
+	modulo := func(i int64) int64 {
+		return ((i % 4096) + 4096) % 4096
+	}
+
+	x := unsignedToSigned(modulo(i))
+	i = (i - int64(x)) / 4096
+	y := unsignedToSigned(modulo(i))
+	i = (i - int64(y)) / 4096
+	z := unsignedToSigned(modulo(i))
+
+	return BlockPosition{X: x, Y: y, Z: z}
+}
+
+func formatPosition(x, y, z int) string {
+	return "(" + strconv.Itoa(x) + "," + strconv.Itoa(y) + "," + strconv.Itoa(z) + ")"
+}
+
+func parsePosition(position string) ([3]int, bool) {
+	var coords [3]int
+
+	trimmed := strings.TrimSpace(position)
+	if strings.HasPrefix(trimmed, "(") {
+		if !strings.HasSuffix(trimmed, ")") {
+			return coords, false
+		}
+		trimmed = trimmed[1 : len(trimmed)-1]
+	}
+
+	parts := strings.Split(trimmed, ",")
+	if len(parts) != 3 {
+		return coords, false
+	}
+
+	for i, part := range parts {
+		coord, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return coords, false
+		}
+		coords[i] = coord
+	}
+
+	return coords, true
+}
@@ -0,0 +1,36 @@
+package spatial
+
+import "testing"
+
+// Keys as computed by Minetest's MapDatabase::getBlockAsInteger
+var blockKeys = []struct {
+	pos BlockPosition
+	key int64
+}{
+	{BlockPosition{X: 0, Y: 0, Z: 0}, 0},
+	{BlockPosition{X: 1, Y: 2, Z: 3}, 50339841},
+	{BlockPosition{X: -1, Y: 0, Z: 0}, -1},
+	{BlockPosition{X: 0, Y: -1, Z: 0}, -4096},
+	{BlockPosition{X: 0, Y: 0, Z: -1}, -16777216},
+	{BlockPosition{X: -1, Y: -1, Z: -1}, -16781313},
+	{BlockPosition{X: 1, Y: -1, Z: 1}, 16773121},
+	{BlockPosition{X: -32, Y: 5, Z: -100}, -1677701152},
+	{BlockPosition{X: 2047, Y: 2047, Z: 2047}, 34351347711},
+	{BlockPosition{X: -2048, Y: -2048, Z: -2048}, -34368129024},
+}
+
+func TestBlockPosToInteger(t *testing.T) {
+	for _, test := range blockKeys {
+		if got := BlockPosToInteger(test.pos); got != test.key {
+			t.Errorf("BlockPosToInteger(%v) = %v, want %v", test.pos, got, test.key)
+		}
+	}
+}
+
+func TestIntegerToBlockPos(t *testing.T) {
+	for _, test := range blockKeys {
+		if got := IntegerToBlockPos(test.key); got != test.pos {
+			t.Errorf("IntegerToBlockPos(%v) = %v, want %v", test.key, got, test.pos)
+		}
+	}
+}
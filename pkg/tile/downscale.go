@@ -51,31 +51,55 @@ func uniquePositions(input []render.TilePosition) []render.TilePosition {
 	return input[:j]
 }
 
-// downscalePositions produces downscaled images for given zoom level and returns a list of produced tile positions
-func (t *Tiler) downscalePositions(zoom int, positions []render.TilePosition) []render.TilePosition {
-	const quadrantSize = 128
+// resizeTile scales img to a size×size tile
+func resizeTile(img image.Image, size int) *image.NRGBA {
+	resized := resize.Resize(uint(size), uint(size), img, resize.Lanczos3)
 
-	var nextPositions []render.TilePosition
-
-	for _, pos := range positions {
-		target := image.NewNRGBA(image.Rect(0, 0, 256, 256))
+	tile := image.NewNRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(tile, tile.Rect, resized, resized.Bounds().Min, draw.Src)
 
-		for quadrantY := 0; quadrantY < 2; quadrantY++ {
-			for quadrantX := 0; quadrantX < 2; quadrantX++ {
-				source, err := raster.LoadPNG(t.tilePath(pos.X*2+quadrantX, pos.Y*2+quadrantY, zoom-1))
-				if err != nil {
-					continue
-				}
+	return tile
+}
 
-				quadrant := resize.Resize(quadrantSize, quadrantSize, source, resize.Lanczos3)
+// downscaleTile combines the four tiles of zoom-1 covering the tile at pos
+// into one tile of zoom. The second value is false if none of them exist.
+func (t *Tiler) downscaleTile(zoom int, pos render.TilePosition) (*image.NRGBA, bool) {
+	quadrantSize := t.tileSize / 2
+	target := image.NewNRGBA(image.Rect(0, 0, t.tileSize, t.tileSize))
+	found := false
+
+	for quadrantY := 0; quadrantY < 2; quadrantY++ {
+		for quadrantX := 0; quadrantX < 2; quadrantX++ {
+			sourcePath, format, exists := t.findTile(pos.X*2+quadrantX, pos.Y*2+quadrantY, zoom-1)
+			if !exists {
+				continue
+			}
 
-				targetX := quadrantX * quadrantSize
-				targetY := quadrantY * quadrantSize
-				draw.Draw(target, image.Rect(targetX, targetY, targetX+quadrantSize, targetY+quadrantSize), quadrant, image.Pt(0, 0), draw.Src)
+			source, err := raster.LoadImage(sourcePath, format)
+			if err != nil {
+				continue
 			}
+
+			quadrant := resize.Resize(uint(quadrantSize), uint(quadrantSize), source, resize.Lanczos3)
+
+			targetX := quadrantX * quadrantSize
+			targetY := quadrantY * quadrantSize
+			draw.Draw(target, image.Rect(targetX, targetY, targetX+quadrantSize, targetY+quadrantSize), quadrant, image.Pt(0, 0), draw.Src)
+			found = true
 		}
+	}
+
+	return target, found
+}
+
+// downscalePositions produces downscaled images for given zoom level and returns a list of produced tile positions
+func (t *Tiler) downscalePositions(zoom int, positions []render.TilePosition) []render.TilePosition {
+	var nextPositions []render.TilePosition
+
+	for _, pos := range positions {
+		target, _ := t.downscaleTile(zoom, pos)
 
-		err := raster.SavePNG(target, t.tilePath(pos.X, pos.Y, zoom))
+		_, err := t.saveTile(target, pos.X, pos.Y, zoom)
 		if err != nil {
 			panic(err)
 		}
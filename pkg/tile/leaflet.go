@@ -0,0 +1,128 @@
+package tile
+
+import (
+	"html/template"
+	"io"
+	"os"
+	"path"
+)
+
+var leafletPage = template.Must(template.New("index.html").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>{{.Title}}</title>
+	<link rel="stylesheet" href="https://unpkg.com/leaflet@1.7.1/dist/leaflet.css">
+	<script src="https://unpkg.com/leaflet@1.7.1/dist/leaflet.js"></script>
+	<style>
+		html, body, #map {
+			margin: 0;
+			height: 100%;
+			background-color: black;
+		}
+	</style>
+</head>
+<body>
+	<div id="map"></div>
+	<script>
+		// Latitude is X and longitude is Z of world coordinates. Tiles of zoom
+		// level 0 are scaled so that a node is scale pixels wide.
+		const scale = {{.Scale}};
+		const projection = {{if .Isometric}}{
+			project: (latlng) => new L.Point(
+				(latlng.lng - latlng.lat) * scale / 2,
+				(latlng.lng + latlng.lat) * scale / 4),
+			unproject: (point) => new L.LatLng(
+				(2 * point.y - point.x) / scale,
+				(2 * point.y + point.x) / scale),
+		}{{else}}{
+			project: (latlng) => new L.Point(latlng.lat * scale, -latlng.lng * scale),
+			unproject: (point) => new L.LatLng(point.x / scale, -point.y / scale),
+		}{{end}};
+
+		const map = L.map('map', {
+			crs: L.extend({}, L.CRS.Simple, {
+				projection: projection,
+				transformation: new L.Transformation(1, 0, 1, 0),
+			}),
+		}).setView([{{.CenterX}}, {{.CenterZ}}], 0);
+
+		// Tiles with transparent parts are saved as PNG when the tile format
+		// can't store transparency, so load those instead if a tile is missing
+		const extension = {{.Extension}};
+		const fallbackExtension = {{.FallbackExtension}};
+		const TileLayer = L.TileLayer.extend({
+			_tileOnError: function (done, tile, e) {
+				if (fallbackExtension && !tile.triedFallback) {
+					tile.triedFallback = true;
+					tile.src = tile.src.slice(0, -extension.length) + fallbackExtension;
+					return;
+				}
+				L.TileLayer.prototype._tileOnError.call(this, done, tile, e);
+			},
+		});
+
+		new TileLayer('{z}/{x}/{y}' + extension, {
+			minZoom: -{{.ZoomLevels}},
+			maxZoom: 0,
+			tileSize: {{.TileSize}},
+			noWrap: true,
+		}).addTo(map);
+	</script>
+</body>
+</html>
+`))
+
+// WriteLeafletPage writes index.html showing the tiles with Leaflet to the
+// tile directory, so that it can be served by any web server. isometric
+// selects the projection matching the isometric renderer instead of the
+// topdown one, and nodeSize is the width of a node in rendered tiles.
+func (t *Tiler) WriteLeafletPage(title string, isometric bool, nodeSize int) error {
+	file, err := os.Create(path.Join(t.tilesPath, "index.html"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := t.LeafletPage(file, title, isometric, nodeSize); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// LeafletPage writes the page saved by WriteLeafletPage to w. Tiles are
+// loaded from URLs relative to the page.
+func (t *Tiler) LeafletPage(w io.Writer, title string, isometric bool, nodeSize int) error {
+	// Rendered tiles may have been resized to tileSize
+	scale := float64(nodeSize) * float64(t.tileSize) / DefaultTileSize
+
+	fallbackExtension := ""
+	if formats := t.tileFormats(); len(formats) > 1 {
+		fallbackExtension = formats[1].Extension()
+	}
+
+	return leafletPage.Execute(w, struct {
+		Title      string
+		Isometric  bool
+		Scale      float64
+		CenterX    float64
+		CenterZ    float64
+		ZoomLevels int
+		TileSize   int
+		Extension  string
+		// FallbackExtension is empty if all tiles have the same format
+		FallbackExtension string
+	}{
+		Title:             title,
+		Isometric:         isometric,
+		Scale:             scale,
+		CenterX:           float64(t.region.XBounds.Min+t.region.XBounds.Max) / 2,
+		CenterZ:           float64(t.region.ZBounds.Min+t.region.ZBounds.Max) / 2,
+		ZoomLevels:        t.zoomLevels,
+		TileSize:          t.tileSize,
+		Extension:         t.format.Extension(),
+		FallbackExtension: fallbackExtension,
+	})
+}
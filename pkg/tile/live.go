@@ -0,0 +1,237 @@
+package tile
+
+import (
+	"context"
+	"errors"
+	"image"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/weqqr/panorama/pkg/game"
+	"github.com/weqqr/panorama/pkg/logging"
+	"github.com/weqqr/panorama/pkg/render"
+	"github.com/weqqr/panorama/pkg/spatial"
+	"github.com/weqqr/panorama/pkg/world"
+)
+
+// ErrEmptyTile is returned by LiveTiler.Tile for tiles with nothing drawn on
+// them
+var ErrEmptyTile = errors.New("tile is empty")
+
+type liveTileKey struct {
+	X, Y, Zoom int
+}
+
+// LiveTiler renders tiles when they're requested instead of ahead of time.
+// Tiles are saved to the tile directory, in the same layout as FullRender
+// and DownscaleTiles use, and reused until they're older than maxAge.
+type LiveTiler struct {
+	tiler     *Tiler
+	game      *game.Game
+	world     *world.World
+	region    spatial.TileRegion
+	maxAge    time.Duration
+	renderers chan render.Renderer
+
+	mutex sync.Mutex
+	// pending contains tiles being rendered. The channel is closed once the
+	// tile is done.
+	pending map[liveTileKey]chan struct{}
+	// empty contains times when tiles with nothing drawn on them were
+	// rendered, since they aren't saved
+	empty map[liveTileKey]time.Time
+}
+
+// NewLiveTiler creates a LiveTiler rendering up to workers tiles of region
+// at once. Tiles are rendered again once they're older than maxAge, or never
+// if it's zero.
+func NewLiveTiler(tiler *Tiler, game *game.Game, world *world.World, workers int, region spatial.TileRegion, createRenderer CreateRendererFunc, maxAge time.Duration) *LiveTiler {
+	renderers := make(chan render.Renderer, workers)
+	for i := 0; i < workers; i++ {
+		renderers <- createRenderer()
+	}
+
+	return &LiveTiler{
+		tiler:     tiler,
+		game:      game,
+		world:     world,
+		region:    region,
+		maxAge:    maxAge,
+		renderers: renderers,
+		pending:   make(map[liveTileKey]chan struct{}),
+		empty:     make(map[liveTileKey]time.Time),
+	}
+}
+
+// Extension returns the file name extension tiles are requested with. Tiles
+// with transparent parts may be saved in another format, see Tile.
+func (l *LiveTiler) Extension() string {
+	return l.tiler.format.Extension()
+}
+
+// Tile returns the path of the tile file at x, y, rendering it first if it's
+// missing or outdated. The extension of the path tells the format the tile is
+// saved in. Zoom levels above 0 are downscaled from tiles of the
+// previous level, rendering them as needed. Concurrent requests for the same
+// tile wait for a single render.
+func (l *LiveTiler) Tile(ctx context.Context, x, y, zoom int) (string, error) {
+	if zoom < 0 || zoom > l.tiler.zoomLevels {
+		return "", ErrEmptyTile
+	}
+
+	key := liveTileKey{X: x, Y: y, Zoom: zoom}
+
+	for {
+		if tilePath, fresh, empty := l.cached(key); fresh {
+			if empty {
+				return "", ErrEmptyTile
+			}
+			return tilePath, nil
+		}
+
+		l.mutex.Lock()
+		if done, ok := l.pending[key]; ok {
+			l.mutex.Unlock()
+
+			// Check the cache again once the other render is done, it
+			// may have been cancelled
+			select {
+			case <-done:
+				continue
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		}
+
+		done := make(chan struct{})
+		l.pending[key] = done
+		l.mutex.Unlock()
+
+		err := l.render(ctx, key)
+
+		l.mutex.Lock()
+		delete(l.pending, key)
+		close(done)
+		l.mutex.Unlock()
+
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// cached returns the path of the saved tile, and reports whether it's up to
+// date and whether it's empty
+func (l *LiveTiler) cached(key liveTileKey) (string, bool, bool) {
+	l.mutex.Lock()
+	renderedAt, empty := l.empty[key]
+	l.mutex.Unlock()
+
+	var tilePath string
+	if !empty {
+		var exists bool
+		tilePath, _, exists = l.tiler.findTile(key.X, key.Y, key.Zoom)
+		if !exists {
+			return "", false, false
+		}
+
+		info, err := os.Stat(tilePath)
+		if err != nil {
+			return "", false, false
+		}
+		renderedAt = info.ModTime()
+	}
+
+	return tilePath, l.maxAge == 0 || time.Since(renderedAt) < l.maxAge, empty
+}
+
+func (l *LiveTiler) render(ctx context.Context, key liveTileKey) error {
+	img, err := l.renderImage(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	if img == nil {
+		l.mutex.Lock()
+		l.empty[key] = time.Now()
+		l.mutex.Unlock()
+
+		// The tile may have had something drawn on it previously
+		return l.tiler.removeTile(key.X, key.Y, key.Zoom)
+	}
+
+	tilePath, err := l.tiler.saveTile(img, key.X, key.Y, key.Zoom)
+	if err != nil {
+		return err
+	}
+	logging.Debugf("saved %v", tilePath)
+
+	l.mutex.Lock()
+	delete(l.empty, key)
+	l.mutex.Unlock()
+
+	return nil
+}
+
+// renderImage draws the tile, or returns nil if it's empty
+func (l *LiveTiler) renderImage(ctx context.Context, key liveTileKey) (*image.NRGBA, error) {
+	pos := render.TilePosition{X: key.X, Y: key.Y}
+
+	if key.Zoom > 0 {
+		for quadrantY := 0; quadrantY < 2; quadrantY++ {
+			for quadrantX := 0; quadrantX < 2; quadrantX++ {
+				_, err := l.Tile(ctx, pos.X*2+quadrantX, pos.Y*2+quadrantY, key.Zoom-1)
+				if err != nil && !errors.Is(err, ErrEmptyTile) {
+					return nil, err
+				}
+			}
+		}
+
+		img, found := l.tiler.downscaleTile(key.Zoom, pos)
+		if !found {
+			return nil, nil
+		}
+
+		return img, nil
+	}
+
+	if pos.X < l.region.XBounds.Min || pos.X >= l.region.XBounds.Max ||
+		pos.Y < l.region.YBounds.Min || pos.Y >= l.region.YBounds.Max {
+		return nil, nil
+	}
+
+	var renderer render.Renderer
+	select {
+	case renderer = <-l.renderers:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { l.renderers <- renderer }()
+
+	output, err := renderer.RenderTile(ctx, pos, l.world, l.game)
+
+	// A cancelled render leaves the tile unfinished, so it mustn't be cached
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !output.Dirty {
+		return nil, nil
+	}
+
+	// The output buffer is reused by the renderer, so it's copied before the
+	// renderer is released
+	tile := output.Color
+	if tile.Rect.Dx() != l.tiler.tileSize || tile.Rect.Dy() != l.tiler.tileSize {
+		return resizeTile(tile, l.tiler.tileSize), nil
+	}
+
+	copied := *tile
+	copied.Pix = append([]uint8(nil), tile.Pix...)
+	return &copied, nil
+}
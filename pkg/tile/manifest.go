@@ -0,0 +1,95 @@
+package tile
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/weqqr/panorama/pkg/render"
+)
+
+const manifestName = "manifest.json"
+
+// manifest records the newest timestamp of blocks drawn on each tile when it
+// was rendered last time. Tiles are rendered again only if one of their
+// blocks has been saved since then.
+type manifest struct {
+	mutex      sync.Mutex
+	timestamps map[render.TilePosition]uint32
+}
+
+type manifestEntry struct {
+	X         int    `json:"x"`
+	Y         int    `json:"y"`
+	Timestamp uint32 `json:"timestamp"`
+}
+
+// loadManifest reads the manifest from path. A missing file results in an
+// empty manifest.
+func loadManifest(path string) (*manifest, error) {
+	m := &manifest{
+		timestamps: make(map[render.TilePosition]uint32),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []manifestEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		m.timestamps[render.TilePosition{X: entry.X, Y: entry.Y}] = entry.Timestamp
+	}
+
+	return m, nil
+}
+
+// isOutdated reports whether the tile at pos must be rendered again because
+// it contains blocks saved at timestamp
+func (m *manifest) isOutdated(pos render.TilePosition, timestamp uint32) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	rendered, ok := m.timestamps[pos]
+	return !ok || timestamp > rendered
+}
+
+func (m *manifest) update(pos render.TilePosition, timestamp uint32) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.timestamps[pos] = timestamp
+}
+
+func (m *manifest) save(dir string) error {
+	m.mutex.Lock()
+	entries := make([]manifestEntry, 0, len(m.timestamps))
+	for pos, timestamp := range m.timestamps {
+		entries = append(entries, manifestEntry{X: pos.X, Y: pos.Y, Timestamp: timestamp})
+	}
+	m.mutex.Unlock()
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so that an interrupted save doesn't
+	// corrupt the manifest
+	temp := path.Join(dir, manifestName+".tmp")
+	if err := os.WriteFile(temp, data, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(temp, path.Join(dir, manifestName))
+}
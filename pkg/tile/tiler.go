@@ -1,9 +1,11 @@
 package tile
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"image"
 	"io/fs"
-	"log"
 	"os"
 	"path"
 	"path/filepath"
@@ -13,61 +15,312 @@ import (
 
 	"github.com/weqqr/panorama/pkg/game"
 	"github.com/weqqr/panorama/pkg/lm"
+	"github.com/weqqr/panorama/pkg/logging"
 	"github.com/weqqr/panorama/pkg/raster"
 	"github.com/weqqr/panorama/pkg/render"
 	"github.com/weqqr/panorama/pkg/spatial"
 	"github.com/weqqr/panorama/pkg/world"
 )
 
+// DefaultTileSize is the size of tiles produced by renderers
+const DefaultTileSize = 256
+
 type Tiler struct {
 	region     spatial.Region
 	zoomLevels int
+	tileSize   int
 	tilesPath  string
+	format     raster.ImageFormat
+	quality    int
 }
 
-func NewTiler(region spatial.Region, zoomLevels int, tilesPath string) Tiler {
+// NewTiler creates a tiler saving tiles of tileSize×tileSize pixels in
+// format. Rendered tiles are resized if their size differs. quality is used
+// by lossy formats.
+func NewTiler(region spatial.Region, zoomLevels, tileSize int, tilesPath string, format raster.ImageFormat, quality int) Tiler {
 	return Tiler{
 		region:     region,
 		zoomLevels: zoomLevels,
+		tileSize:   tileSize,
 		tilesPath:  tilesPath,
+		format:     format,
+		quality:    quality,
 	}
 }
 
+// tilePath returns the path of the tile saved in the configured format
 func (t *Tiler) tilePath(x, y, zoom int) string {
-	return fmt.Sprintf("%v/%v/%v/%v.png", t.tilesPath, -zoom, x, y)
+	return t.tilePathAs(x, y, zoom, t.format)
+}
+
+func (t *Tiler) tilePathAs(x, y, zoom int, format raster.ImageFormat) string {
+	return fmt.Sprintf("%v/%v/%v/%v%v", t.tilesPath, -zoom, x, y, format.Extension())
+}
+
+// tileFormats lists formats tiles may be saved in, the configured one first.
+// Tiles with transparent parts can't be saved in formats without alpha, so
+// they're saved as PNG instead.
+func (t *Tiler) tileFormats() []raster.ImageFormat {
+	if t.format.SupportsAlpha() {
+		return []raster.ImageFormat{t.format}
+	}
+	return []raster.ImageFormat{t.format, raster.ImageFormatPNG}
+}
+
+// findTile returns the path and format of the saved tile. The last value is
+// false if the tile doesn't exist.
+func (t *Tiler) findTile(x, y, zoom int) (string, raster.ImageFormat, bool) {
+	for _, format := range t.tileFormats() {
+		tilePath := t.tilePathAs(x, y, zoom, format)
+		if _, err := os.Stat(tilePath); err == nil {
+			return tilePath, format, true
+		}
+	}
+
+	return "", t.format, false
+}
+
+// saveTile saves img as the tile and returns its path. The tile is saved in
+// the configured format unless it has transparent parts that the format
+// can't keep. Copies of the tile in other formats are removed, so that they
+// aren't found instead.
+func (t *Tiler) saveTile(img *image.NRGBA, x, y, zoom int) (string, error) {
+	format := t.format.ForImage(img)
+	tilePath := t.tilePathAs(x, y, zoom, format)
+
+	if err := raster.SaveImage(img, tilePath, format, t.quality); err != nil {
+		return "", err
+	}
+
+	for _, other := range t.tileFormats() {
+		if other == format {
+			continue
+		}
+
+		err := os.Remove(t.tilePathAs(x, y, zoom, other))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+	}
+
+	return tilePath, nil
+}
+
+// removeTile deletes the tile in every format it may be saved in
+func (t *Tiler) removeTile(x, y, zoom int) error {
+	for _, format := range t.tileFormats() {
+		err := os.Remove(t.tilePathAs(x, y, zoom, format))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newestTimestamp returns the newest timestamp of blocks drawn on the tile at
+// pos. The second value is false if the blocks couldn't be fetched.
+func newestTimestamp(ctx context.Context, w *world.World, renderer render.Renderer, pos render.TilePosition) (uint32, bool) {
+	blocks, errs := w.GetBlocks(ctx, renderer.TileBlocks(pos))
+
+	newest := uint32(0)
+	for i, block := range blocks {
+		// Renderers skip undecodable blocks, so they don't make the tile
+		// outdated either
+		if errors.Is(errs[i], world.ErrBlockNotFound) || world.IsDecodeError(errs[i]) {
+			continue
+		}
+
+		if errs[i] != nil {
+			logging.Errorf("fetching blocks of tile %v: %v", pos, errs[i])
+			return 0, false
+		}
+
+		if block.Timestamp() > newest {
+			newest = block.Timestamp()
+		}
+	}
+
+	return newest, true
 }
 
-func (t *Tiler) worker(wg *sync.WaitGroup, game *game.Game, world *world.World, renderer render.Renderer, positions <-chan render.TilePosition) {
+// renderTile renders and saves the tile at position. With a manifest, the
+// tile is skipped if it's up to date. If resume is set, tiles that have been
+// saved already are skipped too.
+func (t *Tiler) renderTile(ctx context.Context, game *game.Game, world *world.World, renderer render.Renderer, manifest *manifest, resume bool, position render.TilePosition) error {
+	if resume {
+		if _, _, exists := t.findTile(position.X, position.Y, 0); exists {
+			return nil
+		}
+	}
+
+	var timestamp uint32
+	var timestampKnown bool
+	if manifest != nil {
+		timestamp, timestampKnown = newestTimestamp(ctx, world, renderer, position)
+		if timestampKnown && !manifest.isOutdated(position, timestamp) {
+			return nil
+		}
+	}
+
+	output, err := renderer.RenderTile(ctx, position, world, game)
+
+	// A cancelled render leaves the tile unfinished, so it's neither saved
+	// nor recorded in the manifest, and --resume renders it again
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	// A failed tile isn't recorded in the manifest either, so that
+	// --incremental tries it again next time
+	if err != nil {
+		return err
+	}
+
+	// Don't save empty tiles
+	if output.Dirty {
+		tile := output.Color
+		if tile.Rect.Dx() != t.tileSize || tile.Rect.Dy() != t.tileSize {
+			tile = resizeTile(tile, t.tileSize)
+		}
+
+		tilePath, err := t.saveTile(tile, position.X, position.Y, 0)
+		if err != nil {
+			return fmt.Errorf("saving: %w", err)
+		}
+		logging.Debugf("saved %v", tilePath)
+	}
+
+	if timestampKnown {
+		manifest.update(position, timestamp)
+	}
+
+	return nil
+}
+
+func (t *Tiler) worker(ctx context.Context, wg *sync.WaitGroup, game *game.Game, world *world.World, renderer render.Renderer, manifest *manifest, resume bool, progress *progress, positions <-chan render.TilePosition) {
+	defer wg.Done()
+
 	for position := range positions {
-		output := renderer.RenderTile(position, world, game)
-		// Don't save empty tiles
-		if !output.Dirty {
+		// Tiles still queued when the render is cancelled are skipped
+		if ctx.Err() != nil {
 			continue
 		}
 
-		tilePath := t.tilePath(position.X, position.Y, 0)
-		err := raster.SavePNG(output.Color, tilePath)
+		err := t.renderTile(ctx, game, world, renderer, manifest, resume, position)
 		if err != nil {
-			return
+			logging.Errorf("rendering tile %v: %v", position, err)
 		}
-		log.Printf("saved %v", tilePath)
+
+		progress.advance()
+	}
+}
+
+// ProgressFunc is called by FullRender as tiles are completed, where done of
+// total tiles have been processed, including skipped ones. Calls don't
+// overlap, and done only increases.
+type ProgressFunc func(done, total int)
+
+type progress struct {
+	mutex    sync.Mutex
+	done     int
+	total    int
+	callback ProgressFunc
+}
+
+func (p *progress) advance() {
+	if p.callback == nil {
+		return
 	}
 
-	wg.Done()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.done++
+	p.callback(p.done, p.total)
+}
+
+// prefetch fetches all blocks the renderer reads for every tile from
+// positions using the batch API and passes the tile on to out. Decoded blocks
+// end up in the world's block cache, and their data in the backend's cache if
+// cache.size enables one. Errors are left for the render to report.
+func (t *Tiler) prefetch(ctx context.Context, world *world.World, renderer render.Renderer, resume bool, positions <-chan render.TilePosition, out chan<- render.TilePosition) {
+	defer close(out)
+
+	for position := range positions {
+		skipped := false
+		if resume {
+			_, _, skipped = t.findTile(position.X, position.Y, 0)
+		}
+
+		if !skipped && ctx.Err() == nil {
+			world.GetBlocks(ctx, renderer.ReadBlocks(position))
+		}
+
+		out <- position
+	}
 }
 
 type CreateRendererFunc func() render.Renderer
 
-func (t *Tiler) FullRender(game *game.Game, world *world.World, workers int, region spatial.TileRegion, createRenderer CreateRendererFunc) {
+// FullRenderOptions changes which tiles FullRender renders and how it reports
+// its progress
+type FullRenderOptions struct {
+	// Incremental makes FullRender render tiles only if their blocks have
+	// been saved after the tile was rendered last time, according to the
+	// manifest in the tile directory
+	Incremental bool
+	// Resume skips tiles that have been saved already, so that an
+	// interrupted render can be continued
+	Resume bool
+	// Prefetch loads blocks of upcoming tiles in the background while the
+	// workers render, so that they're already cached when they're needed
+	Prefetch bool
+	// OnProgress is called as tiles are completed. It may be nil.
+	OnProgress ProgressFunc
+}
+
+// FullRender renders all tiles in region. Once ctx is cancelled, no more tiles
+// are started, tiles being saved are finished and the manifest is saved
+// before it returns.
+func (t *Tiler) FullRender(ctx context.Context, game *game.Game, world *world.World, workers int, region spatial.TileRegion, createRenderer CreateRendererFunc, options FullRenderOptions) {
 	var wg sync.WaitGroup
 	positions := make(chan render.TilePosition)
 
+	progress := &progress{
+		total:    (region.XBounds.Max - region.XBounds.Min) * (region.YBounds.Max - region.YBounds.Min),
+		callback: options.OnProgress,
+	}
+	if options.OnProgress != nil {
+		options.OnProgress(0, progress.total)
+	}
+
+	var m *manifest
+	if options.Incremental {
+		var err error
+		m, err = loadManifest(path.Join(t.tilesPath, manifestName))
+		if err != nil {
+			logging.Warnf("Unable to load tile manifest, rendering all tiles: %v", err)
+			m = &manifest{timestamps: make(map[render.TilePosition]uint32)}
+		}
+	}
+
+	tiles := positions
+	if options.Prefetch {
+		// Buffering lets prefetching run ahead of the workers by one tile
+		// per worker
+		prefetched := make(chan render.TilePosition, workers)
+		go t.prefetch(ctx, world, createRenderer(), options.Resume, positions, prefetched)
+		tiles = prefetched
+	}
+
 	for i := 0; i < workers; i++ {
 		wg.Add(1)
 		renderer := createRenderer()
-		go t.worker(&wg, game, world, renderer, positions)
+		go t.worker(ctx, &wg, game, world, renderer, m, options.Resume, progress, tiles)
 	}
 
+dispatch:
 	for x := region.XBounds.Min; x < region.XBounds.Max; x++ {
 		err := os.MkdirAll(fmt.Sprintf("%v/%v", path.Join(t.tilesPath, "0"), x), os.ModePerm)
 		if err != nil {
@@ -75,17 +328,27 @@ func (t *Tiler) FullRender(game *game.Game, world *world.World, workers int, reg
 		}
 
 		for y := region.YBounds.Min; y < region.YBounds.Max; y++ {
-			positions <- render.TilePosition{X: x, Y: y}
+			select {
+			case positions <- render.TilePosition{X: x, Y: y}:
+			case <-ctx.Done():
+				break dispatch
+			}
 		}
 	}
 	close(positions)
 
 	wg.Wait()
+
+	if m != nil {
+		if err := m.save(t.tilesPath); err != nil {
+			logging.Errorf("Unable to save tile manifest: %v", err)
+		}
+	}
 }
 
 // DownscaleTiles rescales high-resolution tiles into lower resolution ones until it reaches adequate zoom level
 func (t *Tiler) DownscaleTiles() {
-	log.Printf("Downscaling zoomLevels=%v", t.zoomLevels)
+	logging.Infof("Downscaling zoomLevels=%v", t.zoomLevels)
 
 	tileDir, err := filepath.Abs(path.Join(t.tilesPath, "0"))
 	if err != nil {
@@ -126,7 +389,7 @@ func (t *Tiler) DownscaleTiles() {
 	positions = uniquePositions(positions)
 
 	for zoom := 1; zoom <= t.zoomLevels; zoom++ {
-		log.Printf("Rescaling tiles for zoom level %v", zoom)
+		logging.Infof("Rescaling tiles for zoom level %v", zoom)
 		positions = t.downscalePositions(zoom, positions)
 	}
 }
@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"bytes"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/weqqr/panorama/pkg/world"
+)
+
+// Metrics serves block reading counters in the Prometheus text format
+func Metrics(counters *world.Counters) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		var out bytes.Buffer
+		if err := counters.WritePrometheus(&out); err != nil {
+			return err
+		}
+
+		c.Set(fiber.HeaderContentType, "text/plain; version=0.0.4")
+		return c.Send(out.Bytes())
+	}
+}
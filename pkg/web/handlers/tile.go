@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/weqqr/panorama/pkg/logging"
+	"github.com/weqqr/panorama/pkg/tile"
+)
+
+// renderTimeout limits how long a single request may spend rendering. Tiles
+// finished before the timeout stay cached, so retried requests continue
+// where the previous one stopped.
+const renderTimeout = time.Minute
+
+// Tile serves tiles at /:z/:x/:y, rendering them on demand. Zoom levels are
+// numbered like the directories of the tile storage, from 0 for full
+// resolution down to -zoom_levels.
+func Tile(live *tile.LiveTiler) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		z, errZ := strconv.Atoi(c.Params("z"))
+		x, errX := strconv.Atoi(c.Params("x"))
+
+		name := c.Params("y")
+		y, errY := strconv.Atoi(strings.TrimSuffix(name, live.Extension()))
+		if errZ != nil || errX != nil || errY != nil || !strings.HasSuffix(name, live.Extension()) {
+			return fiber.ErrNotFound
+		}
+
+		// The request context is cancelled when the server shuts down
+		ctx, cancel := context.WithTimeout(c.Context(), renderTimeout)
+		defer cancel()
+
+		tilePath, err := live.Tile(ctx, x, y, -z)
+		if errors.Is(err, tile.ErrEmptyTile) {
+			return fiber.ErrNotFound
+		}
+
+		if err != nil {
+			logging.Errorf("rendering tile %v/%v/%v: %v", z, x, y, err)
+			return fiber.ErrServiceUnavailable
+		}
+
+		// Tiles are read every time instead of using SendFile, which caches
+		// files and could serve a tile after it has been rendered again
+		data, err := os.ReadFile(tilePath)
+		if err != nil {
+			return err
+		}
+
+		// Tiles with transparent parts may be saved in another format than
+		// the one they're requested as
+		c.Type(strings.TrimPrefix(filepath.Ext(tilePath), "."))
+		return c.Send(data)
+	}
+}
+
+// Page serves a fixed HTML page
+func Page(page []byte) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		c.Type("html")
+		return c.Send(page)
+	}
+}
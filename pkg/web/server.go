@@ -1,12 +1,13 @@
 package web
 
 import (
-	"log"
-
 	"github.com/gofiber/fiber/v2"
 
 	"github.com/weqqr/panorama/pkg/config"
+	"github.com/weqqr/panorama/pkg/logging"
+	"github.com/weqqr/panorama/pkg/tile"
 	"github.com/weqqr/panorama/pkg/web/handlers"
+	"github.com/weqqr/panorama/pkg/world"
 )
 
 func Serve(config *config.Config) {
@@ -19,5 +20,20 @@ func Serve(config *config.Config) {
 
 	app.Get("/metadata.json", handlers.Metadata(config))
 
-	log.Fatal(app.Listen(config.Web.ListenAddress))
+	logging.Fatalf("Unable to serve tiles: %v", app.Listen(config.Web.ListenAddress))
+}
+
+// ServeLive serves tiles rendered on demand by live at /{z}/{x}/{y}, and page
+// at the root. If counters isn't nil, they're served at /metrics.
+func ServeLive(config *config.Config, live *tile.LiveTiler, page []byte, counters *world.Counters) {
+	app := fiber.New()
+
+	app.Get("/", handlers.Page(page))
+	app.Get("/metadata.json", handlers.Metadata(config))
+	if counters != nil {
+		app.Get("/metrics", handlers.Metrics(counters))
+	}
+	app.Get("/:z/:x/:y", handlers.Tile(live))
+
+	logging.Fatalf("Unable to serve tiles: %v", app.Listen(config.Web.ListenAddress))
 }
@@ -1,10 +1,14 @@
 package world
 
 import (
+	"bufio"
 	"bytes"
 	"compress/zlib"
 	"encoding/binary"
+	"fmt"
 	"io"
+	"sync"
+	"time"
 
 	"github.com/klauspost/compress/zstd"
 	"github.com/weqqr/panorama/pkg/spatial"
@@ -12,6 +16,13 @@ import (
 
 const NodeSizeInBytes = 4
 
+// Range of supported MapBlock serialization versions. Versions before 29 use
+// zlib instead of zstd and have a different layout.
+const (
+	minBlockVersion = 25
+	maxBlockVersion = 29
+)
+
 type Node struct {
 	ID     uint16
 	Param1 uint8
@@ -30,24 +41,135 @@ func readU16(r io.Reader) (uint16, error) {
 	return value, err
 }
 
-func readString(r io.Reader) (string, error) {
-	length, err := readU16(r)
+func readU32(r io.Reader) (uint32, error) {
+	var value uint32
+	err := binary.Read(r, binary.BigEndian, &value)
+	return value, err
+}
+
+// blockReader reads serialized block data, either from memory or directly
+// from a decompressed stream
+type blockReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// skip advances reader by n bytes. Unlike Seek, it fails if there's not
+// enough data left.
+func skip(reader blockReader, n int64) error {
+	if r, ok := reader.(*bytes.Reader); ok {
+		if int64(r.Len()) < n {
+			return io.ErrUnexpectedEOF
+		}
+
+		_, err := r.Seek(n, io.SeekCurrent)
+		return err
+	}
+
+	_, err := io.CopyN(io.Discard, reader, n)
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func readBytes(reader blockReader, length int) ([]byte, error) {
+	r, ok := reader.(*bytes.Reader)
+	if !ok {
+		// The length of a stream isn't known in advance, so the buffer
+		// grows as data arrives instead of trusting length, which may be
+		// garbage
+		buf, err := io.ReadAll(io.LimitReader(reader, int64(length)))
+		if err != nil {
+			return nil, err
+		}
+
+		if len(buf) < length {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		return buf, nil
+	}
+
+	// Check length before allocating, as it may be garbage
+	if r.Len() < length {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	buf := make([]byte, length)
+	_, err := io.ReadFull(r, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+func readString(reader blockReader) (string, error) {
+	length, err := readU16(reader)
 	if err != nil {
 		return "", err
 	}
 
-	buf := make([]byte, length)
-	_, err = io.ReadFull(r, buf)
+	buf, err := readBytes(reader, int(length))
+	return string(buf), err
+}
+
+func readLongString(reader blockReader) (string, error) {
+	length, err := readU32(reader)
 	if err != nil {
 		return "", err
 	}
 
-	return string(buf), nil
+	if length > maxLongStringLength {
+		return "", fmt.Errorf("string length %v exceeds %v", length, maxLongStringLength)
+	}
+
+	buf, err := readBytes(reader, int(length))
+	return string(buf), err
+}
+
+func readLine(r io.ByteReader) (string, error) {
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+
+		if b == '\n' {
+			return string(line), nil
+		}
+
+		line = append(line, b)
+	}
 }
 
+// Bits of the MapBlock flags byte
+const (
+	flagIsUnderground   = 0x01
+	flagDayNightDiffers = 0x02
+	flagNotGenerated    = 0x08
+)
+
+// NodeMetadata contains variables set on a single node, such as sign text
+type NodeMetadata map[string]string
+
 type MapBlock struct {
-	mappings map[uint16]string
-	nodeData []byte
+	// names holds names of content IDs below maxMappings, indexed by ID, so
+	// that resolving names of nodes doesn't need a map lookup. Minetest
+	// numbers IDs of every block from 0, so other IDs only appear in blocks
+	// written by other tools, and they're kept in mappings.
+	names     []string
+	mappings  map[uint16]string
+	nodeData  []byte
+	metadata  map[int]NodeMetadata
+	timestamp uint32
+	flags     uint8
+
+	// uniform is set if all nodes have the content ID uniformID
+	uniform   bool
+	uniformID uint16
 }
 
 type ReaderCounter struct {
@@ -74,19 +196,51 @@ func (r *ReaderCounter) ReadByte() (byte, error) {
 	return b, err
 }
 
+// Limits of decoded data. Real blocks stay far below them, so anything larger
+// is treated as corrupt instead of exhausting memory.
+const (
+	// maxDecompressedSize limits the size of decompressed block data
+	maxDecompressedSize = 64 << 20
+	// maxMappings limits name-id mappings. Blocks only map IDs of nodes
+	// they contain, so there can't be more than one per node.
+	maxMappings = spatial.BlockVolume
+	// maxMetadataEntries limits nodes with metadata, of which there can't be
+	// more than nodes in a block
+	maxMetadataEntries = spatial.BlockVolume
+	// maxMetadataVars limits variables of a single node
+	maxMetadataVars = 1 << 16
+	// maxLongStringLength limits metadata values, such as text of books
+	maxLongStringLength = 16 << 20
+)
+
+// readAllLimited reads r until EOF, failing if there's more than
+// maxDecompressedSize bytes
+func readAllLimited(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > maxDecompressedSize {
+		return nil, fmt.Errorf("decompressed data exceeds %v bytes", maxDecompressedSize)
+	}
+
+	return data, nil
+}
+
 func inflate(reader *bytes.Reader) ([]byte, error) {
 	position, _ := reader.Seek(0, io.SeekCurrent)
 
 	counter := NewReaderCounter(reader)
 	z, err := zlib.NewReader(counter)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	defer z.Close()
 
-	data, err := io.ReadAll(z)
+	data, err := readAllLimited(z)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	_, err = reader.Seek(position+counter.count, io.SeekStart)
@@ -97,177 +251,445 @@ func inflate(reader *bytes.Reader) ([]byte, error) {
 	return data, err
 }
 
-func readMappings(reader *bytes.Reader) (map[uint16]string, error) {
-	mappingCount, err := readU16(reader)
+func readNodeMetadata(reader blockReader, metadata map[int]NodeMetadata) error {
+	version, err := readU8(reader)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("reading version: %w", err)
 	}
 
-	mappings := make(map[uint16]string)
-	for i := 0; i < int(mappingCount); i++ {
-		id, err := readU16(reader)
+	// Version 0 is used if the block doesn't contain any metadata
+	if version == 0 {
+		return nil
+	}
+
+	count, err := readU16(reader)
+	if err != nil {
+		return fmt.Errorf("reading count: %w", err)
+	}
+
+	if count > maxMetadataEntries {
+		return fmt.Errorf("metadata count %v exceeds %v", count, maxMetadataEntries)
+	}
+
+	for i := 0; i < int(count); i++ {
+		index, err := readU16(reader)
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("reading entry %v: %w", i, err)
 		}
-		name, err := readString(reader)
+
+		varCount, err := readU32(reader)
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("reading entry %v: %w", i, err)
+		}
+
+		if varCount > maxMetadataVars {
+			return fmt.Errorf("reading entry %v: variable count %v exceeds %v", i, varCount, maxMetadataVars)
 		}
 
-		mappings[id] = name
+		vars := make(NodeMetadata)
+		for j := 0; j < int(varCount); j++ {
+			key, err := readString(reader)
+			if err != nil {
+				return fmt.Errorf("reading entry %v, variable %v: %w", i, j, err)
+			}
+
+			value, err := readLongString(reader)
+			if err != nil {
+				return fmt.Errorf("reading entry %v, variable %v: %w", i, j, err)
+			}
+
+			if version >= 2 {
+				// - uint8 is_private
+				err = skip(reader, 1)
+				if err != nil {
+					return fmt.Errorf("reading entry %v, variable %v: %w", i, j, err)
+				}
+			}
+
+			vars[key] = value
+		}
+
+		// Skip inventory, which is stored as text
+		for {
+			line, err := readLine(reader)
+			if err != nil {
+				return fmt.Errorf("reading entry %v inventory: %w", i, err)
+			}
+
+			if line == "EndInventory" {
+				break
+			}
+		}
+
+		metadata[int(index)] = vars
 	}
 
-	return mappings, nil
+	return nil
 }
 
-func decodeLegacyBlock(reader *bytes.Reader, version uint8) (*MapBlock, error) {
-	if version >= 27 {
-		// - uint8 flags
-		// - uint16 lighting_complete
-		// - uint8 content_width
-		// - uint8 params_width
-		_, err := reader.Seek(1+2+1+1, io.SeekCurrent)
+// zstdStream decompresses the zstd stream of a block. zstd decoders are
+// expensive to create, so streams are reused between blocks.
+type zstdStream struct {
+	decoder  *zstd.Decoder
+	buffered *bufio.Reader
+}
+
+var zstdStreamPool sync.Pool
+
+// openZstdStream starts decompressing the rest of reader. The stream must be
+// released once the block is decoded.
+func openZstdStream(reader *bytes.Reader) (*zstdStream, error) {
+	stream, ok := zstdStreamPool.Get().(*zstdStream)
+	if !ok {
+		// Blocks are small, concurrent decoding would only add overhead
+		decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(maxDecompressedSize))
 		if err != nil {
 			return nil, err
 		}
-	} else {
-		// - uint8 flags
-		// - uint8 content_width
-		// - uint8 params_width
-		_, err := reader.Seek(1+1+1, io.SeekCurrent)
+
+		stream = &zstdStream{decoder: decoder, buffered: bufio.NewReader(nil)}
+	}
+
+	if err := stream.decoder.Reset(reader); err != nil {
+		zstdStreamPool.Put(stream)
+		return nil, err
+	}
+
+	stream.buffered.Reset(io.LimitReader(stream.decoder, maxDecompressedSize))
+	return stream, nil
+}
+
+func (s *zstdStream) release() {
+	// Release the reference to block data before returning the stream to
+	// the pool
+	s.decoder.Reset(nil)
+	s.buffered.Reset(nil)
+	zstdStreamPool.Put(s)
+}
+
+func readMappings(reader blockReader, dst *MapBlock) error {
+	// - uint8 mapping version
+	err := skip(reader, 1)
+	if err != nil {
+		return fmt.Errorf("reading version: %w", err)
+	}
+
+	mappingCount, err := readU16(reader)
+	if err != nil {
+		return fmt.Errorf("reading count: %w", err)
+	}
+
+	if mappingCount > maxMappings {
+		return fmt.Errorf("mapping count %v exceeds %v", mappingCount, maxMappings)
+	}
+
+	for i := 0; i < int(mappingCount); i++ {
+		id, err := readU16(reader)
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("reading entry %v: %w", i, err)
+		}
+		name, err := readString(reader)
+		if err != nil {
+			return fmt.Errorf("reading entry %v: %w", i, err)
+		}
+
+		if int(id) >= maxMappings {
+			dst.mappings[id] = name
+			continue
+		}
+
+		for len(dst.names) <= int(id) {
+			dst.names = append(dst.names, "")
 		}
+		dst.names[id] = name
 	}
 
-	nodeData, err := inflate(reader)
+	return nil
+}
+
+func readWidths(reader blockReader) error {
+	contentWidth, err := readU8(reader)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	_, err = inflate(reader)
+	paramsWidth, err := readU8(reader)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
+	if contentWidth != 2 || paramsWidth != 2 {
+		return fmt.Errorf("unsupported content width %v and params width %v", contentWidth, paramsWidth)
+	}
+
+	return nil
+}
+
+func skipStaticObjects(reader *bytes.Reader) error {
 	// - uint8 staticObjectVersion
-	_, err = reader.Seek(1, io.SeekCurrent)
+	err := skip(reader, 1)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	staticObjectCount, err := readU16(reader)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	for i := 0; i < int(staticObjectCount); i++ {
 		// - uint8 type
 		// - int32 x, y, z
-		_, err = reader.Seek(1+4+4+4, io.SeekCurrent)
+		err = skip(reader, 1+4+4+4)
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("object %v: %w", i, err)
 		}
 		dataSize, err := readU16(reader)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("object %v: %w", i, err)
 		}
-		_, err = reader.Seek(int64(dataSize), io.SeekCurrent)
+		err = skip(reader, int64(dataSize))
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("object %v: %w", i, err)
 		}
 	}
 
-	// - uint32 timestamp
-	// - uint8 mappingVersion
-	_, err = reader.Seek(4+1, io.SeekCurrent)
+	return nil
+}
+
+// reset prepares the block for decoding, reusing previously allocated memory
+func (b *MapBlock) reset() {
+	if len(b.nodeData) != spatial.BlockVolume*NodeSizeInBytes {
+		b.nodeData = make([]byte, spatial.BlockVolume*NodeSizeInBytes)
+	}
+
+	b.names = b.names[:0]
+
+	if b.mappings == nil {
+		b.mappings = make(map[uint16]string)
+	}
+	for id := range b.mappings {
+		delete(b.mappings, id)
+	}
+
+	if b.metadata == nil {
+		b.metadata = make(map[int]NodeMetadata)
+	}
+	for index := range b.metadata {
+		delete(b.metadata, index)
+	}
+
+	b.timestamp = 0
+	b.flags = 0
+	b.uniform = false
+}
+
+func decodeLegacyBlock(reader *bytes.Reader, version uint8, dst *MapBlock) error {
+	var err error
+	dst.flags, err = readU8(reader)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("reading flags: %w", err)
 	}
 
-	mappings, err := readMappings(reader)
+	if version >= 27 {
+		// - uint16 lighting_complete
+		err := skip(reader, 2)
+		if err != nil {
+			return fmt.Errorf("reading lighting_complete: %w", err)
+		}
+	}
+
+	err = readWidths(reader)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("reading widths: %w", err)
+	}
+
+	// Node data and node metadata are compressed separately
+	nodeData, err := inflate(reader)
+	if err != nil {
+		return fmt.Errorf("decompressing node data: %w", err)
+	}
+
+	if len(nodeData) != spatial.BlockVolume*NodeSizeInBytes {
+		return fmt.Errorf("invalid node data size: %v", len(nodeData))
+	}
+
+	copy(dst.nodeData, nodeData)
+
+	metadata, err := inflate(reader)
+	if err != nil {
+		return fmt.Errorf("decompressing node metadata: %w", err)
+	}
+
+	err = readNodeMetadata(bytes.NewReader(metadata), dst.metadata)
+	if err != nil {
+		return fmt.Errorf("reading node metadata: %w", err)
 	}
 
-	return &MapBlock{
-		mappings: mappings,
-		nodeData: nodeData,
-	}, nil
+	err = skipStaticObjects(reader)
+	if err != nil {
+		return fmt.Errorf("reading static objects: %w", err)
+	}
+
+	dst.timestamp, err = readU32(reader)
+	if err != nil {
+		return fmt.Errorf("reading timestamp: %w", err)
+	}
+
+	err = readMappings(reader, dst)
+	if err != nil {
+		return fmt.Errorf("reading name-id mapping: %w", err)
+	}
+
+	return nil
 }
 
-func decodeBlock(reader *bytes.Reader) (*MapBlock, error) {
-	z, err := zstd.NewReader(reader)
+func decodeBlock(data *bytes.Reader, dst *MapBlock) error {
+	// The rest of the block is a single zstd stream, which is parsed as it's
+	// decompressed
+	stream, err := openZstdStream(data)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("decompressing block: %w", err)
 	}
-	defer z.Close()
+	defer stream.release()
 
-	data, err := io.ReadAll(z)
+	reader := stream.buffered
+
+	dst.flags, err = readU8(reader)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("reading flags: %w", err)
+	}
+
+	// Skip uint16 lighting_complete
+	err = skip(reader, 2)
+	if err != nil {
+		return fmt.Errorf("reading lighting_complete: %w", err)
 	}
 
-	reader = bytes.NewReader(data)
+	dst.timestamp, err = readU32(reader)
+	if err != nil {
+		return fmt.Errorf("reading timestamp: %w", err)
+	}
 
-	// Skip:
-	// - uint8 flags
-	// - uint16 lighting_complete
-	// - uint32 timestamp
-	// - uint8 mapping version
-	_, err = reader.Seek(1+2+4+1, io.SeekCurrent)
+	err = readMappings(reader, dst)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("reading name-id mapping: %w", err)
 	}
 
-	mappings, err := readMappings(reader)
+	err = readWidths(reader)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("reading widths: %w", err)
 	}
 
-	// Skip uint8 contentWidth, uint8 paramsWidth
-	_, err = reader.Seek(1+1, io.SeekCurrent)
+	_, err = io.ReadFull(reader, dst.nodeData)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("reading node data: %w", err)
 	}
 
-	nodeData := make([]byte, spatial.BlockVolume*NodeSizeInBytes)
-	_, err = io.ReadFull(reader, nodeData)
+	err = readNodeMetadata(reader, dst.metadata)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("reading node metadata: %w", err)
 	}
 
-	return &MapBlock{
-		mappings: mappings,
-		nodeData: nodeData,
-	}, nil
+	return nil
 }
 
-func DecodeMapBlock(data []byte) (*MapBlock, error) {
+// DecodeMapBlockInto decodes serialized block into dst. Memory used by dst is
+// reused when possible, which makes it cheaper than DecodeMapBlock when many
+// blocks are decoded one after another.
+func DecodeMapBlockInto(data []byte, dst *MapBlock) error {
+	if m := metrics; m != nil {
+		start := time.Now()
+		defer func() { m.BlockDecoded(time.Since(start)) }()
+	}
+
 	reader := bytes.NewReader(data)
 
 	version, err := readU8(reader)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("reading version: %w", err)
+	}
+
+	if version < minBlockVersion || version > maxBlockVersion {
+		return fmt.Errorf("unsupported block version: %v", version)
 	}
 
+	dst.reset()
+
 	if version < 29 {
-		mapblock, err := decodeLegacyBlock(reader, version)
-		if err != nil {
-			panic(err)
+		err = decodeLegacyBlock(reader, version, dst)
+	} else {
+		err = decodeBlock(reader, dst)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	dst.findUniformID()
+	return nil
+}
+
+// findUniformID checks whether all nodes have the same content ID. Content
+// IDs are stored first in node data, as 16-bit values.
+func (b *MapBlock) findUniformID() {
+	ids := b.nodeData[:2*spatial.BlockVolume]
+	for i := 2; i < len(ids); i += 2 {
+		if ids[i] != ids[0] || ids[i+1] != ids[1] {
+			b.uniform = false
+			return
 		}
-		return mapblock, nil
 	}
 
-	return decodeBlock(reader)
+	b.uniform = true
+	b.uniformID = uint16(ids[0])<<8 | uint16(ids[1])
 }
 
+// IsUniform reports whether all nodes of the block are of the same kind, and
+// returns their name if they are
+func (b *MapBlock) IsUniform() (string, bool) {
+	if !b.uniform {
+		return "", false
+	}
+
+	return b.ResolveName(b.uniformID), true
+}
+
+// IsAir reports whether the block contains nothing but air
+func (b *MapBlock) IsAir() bool {
+	name, ok := b.IsUniform()
+	return ok && name == "air"
+}
+
+func DecodeMapBlock(data []byte) (*MapBlock, error) {
+	block := &MapBlock{}
+
+	err := DecodeMapBlockInto(data, block)
+	if err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+// ResolveName returns the name of nodes with content ID id, or an empty
+// string if the block doesn't map it
 func (b *MapBlock) ResolveName(id uint16) string {
+	if int(id) < len(b.names) {
+		return b.names[id]
+	}
+
 	return b.mappings[id]
 }
 
+func nodeIndex(pos spatial.NodePosition) int {
+	return pos.Z*spatial.BlockSize*spatial.BlockSize + pos.Y*spatial.BlockSize + pos.X
+}
+
+// GetNode returns the node at pos. Coordinates aren't validated, use
+// GetNodeChecked if pos may lie outside of the block.
 func (b *MapBlock) GetNode(pos spatial.NodePosition) Node {
-	index := pos.Z*spatial.BlockSize*spatial.BlockSize + pos.Y*spatial.BlockSize + pos.X
+	index := nodeIndex(pos)
 	idHi := uint16(b.nodeData[2*index])
 	idLo := uint16(b.nodeData[2*index+1])
 	param1 := b.nodeData[2*spatial.BlockVolume+index]
@@ -278,3 +700,46 @@ func (b *MapBlock) GetNode(pos spatial.NodePosition) Node {
 		Param2: param2,
 	}
 }
+
+// GetNodeChecked is like GetNode, but returns an error instead of reading the
+// wrong node or panicking if pos lies outside of the block
+func (b *MapBlock) GetNodeChecked(pos spatial.NodePosition) (Node, error) {
+	inBlock := func(v int) bool {
+		return 0 <= v && v < spatial.BlockSize
+	}
+
+	if !inBlock(pos.X) || !inBlock(pos.Y) || !inBlock(pos.Z) {
+		return Node{}, fmt.Errorf("node position %v is outside of block", pos)
+	}
+
+	return b.GetNode(pos), nil
+}
+
+// Metadata returns metadata of the node at pos, or nil if the node doesn't
+// have any
+func (b *MapBlock) Metadata(pos spatial.NodePosition) NodeMetadata {
+	return b.metadata[nodeIndex(pos)]
+}
+
+// Timestamp returns the game time (in seconds) at which the block was last
+// saved
+func (b *MapBlock) Timestamp() uint32 {
+	return b.timestamp
+}
+
+// IsUnderground reports whether the block is considered to be underground,
+// i.e. not reachable by sunlight
+func (b *MapBlock) IsUnderground() bool {
+	return b.flags&flagIsUnderground != 0
+}
+
+// DayNightDiffers reports whether lighting of the block differs between day
+// and night
+func (b *MapBlock) DayNightDiffers() bool {
+	return b.flags&flagDayNightDiffers != 0
+}
+
+// Generated reports whether the mapgen has finished generating the block
+func (b *MapBlock) Generated() bool {
+	return b.flags&flagNotGenerated == 0
+}
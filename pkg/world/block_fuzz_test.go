@@ -0,0 +1,45 @@
+//go:build go1.18
+// +build go1.18
+
+package world
+
+import (
+	"runtime"
+	"testing"
+)
+
+// maxDecodeAllocation bounds memory allocated while decoding a single block.
+// Each of the two zlib streams in legacy blocks may decompress up to
+// maxDecompressedSize, which io.ReadAll can take about twice that to read,
+// and the zstd decoder is limited to maxDecompressedSize too.
+const maxDecodeAllocation = 8 * maxDecompressedSize
+
+// FuzzDecodeMapBlock checks that corrupted blocks are rejected with an error
+// instead of crashing the decoder or allocating unbounded memory
+func FuzzDecodeMapBlock(f *testing.F) {
+	for _, block := range testBlocks {
+		f.Add(readTestBlock(f, block.path))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var dst MapBlock
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		err := DecodeMapBlockInto(data, &dst)
+		runtime.ReadMemStats(&after)
+
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > maxDecodeAllocation {
+			t.Errorf("decoding %v bytes allocated %v bytes", len(data), allocated)
+		}
+
+		if err != nil {
+			return
+		}
+
+		// A block that decodes successfully must be readable
+		for id := 0; id < len(dst.names); id++ {
+			dst.ResolveName(uint16(id))
+		}
+		dst.IsUniform()
+	})
+}
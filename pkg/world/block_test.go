@@ -0,0 +1,92 @@
+package world
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// Blocks in testdata hold the same terrain with a chest, serialized in the
+// legacy and the current format
+var testBlocks = []struct {
+	name string
+	path string
+}{
+	{"v28", "block_v28.bin"},
+	{"v29", "block_v29.bin"},
+}
+
+func readTestBlock(tb testing.TB, name string) []byte {
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return data
+}
+
+func BenchmarkDecodeMapBlock(b *testing.B) {
+	for _, block := range testBlocks {
+		data := readTestBlock(b, block.path)
+		if _, err := DecodeMapBlock(data); err != nil {
+			b.Fatalf("decoding %v: %v", block.path, err)
+		}
+
+		// Decoding into the same block reuses its memory
+		b.Run(block.name+"/reuse", func(b *testing.B) {
+			var dst MapBlock
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				if err := DecodeMapBlockInto(data, &dst); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(block.name+"/alloc", func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				if _, err := DecodeMapBlock(data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		if block.name != "v29" {
+			continue
+		}
+
+		// Emptying the pool makes every block create its own zstd decoder
+		b.Run(block.name+"/unpooled", func(b *testing.B) {
+			defer func() { zstdStreamPool = sync.Pool{} }()
+
+			var dst MapBlock
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				zstdStreamPool = sync.Pool{}
+				if err := DecodeMapBlockInto(data, &dst); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeMapBlockTruncated(t *testing.T) {
+	for _, block := range testBlocks {
+		data := readTestBlock(t, block.path)
+		if _, err := DecodeMapBlock(data); err != nil {
+			t.Fatalf("decoding %v: %v", block.path, err)
+		}
+
+		for length := 0; length < len(data); length++ {
+			if _, err := DecodeMapBlock(data[:length]); err == nil {
+				t.Errorf("decoding the first %v of %v bytes of %v succeeded", length, len(data), block.path)
+			}
+		}
+	}
+}
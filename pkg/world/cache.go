@@ -0,0 +1,103 @@
+package world
+
+import (
+	"context"
+	"errors"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/weqqr/panorama/pkg/spatial"
+)
+
+// CachingBackend wraps another backend and keeps recently fetched block data
+// in an LRU cache. Missing blocks are cached too.
+type CachingBackend struct {
+	inner Backend
+	cache *lru.Cache
+}
+
+func NewCachingBackend(inner Backend, size int) (*CachingBackend, error) {
+	cache, err := lru.New(size)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CachingBackend{
+		inner: inner,
+		cache: cache,
+	}, nil
+}
+
+func (c *CachingBackend) Close() {
+	c.inner.Close()
+}
+
+// lookup returns cached data for pos. cached is false if the cache doesn't
+// have an entry for pos.
+func (c *CachingBackend) lookup(pos spatial.BlockPosition) (data []byte, cached bool, err error) {
+	value, ok := c.cache.Get(pos)
+	recordCacheLookup(ok)
+	if !ok {
+		return nil, false, nil
+	}
+
+	data = value.([]byte)
+	if data == nil {
+		return nil, true, ErrBlockNotFound
+	}
+
+	return data, true, nil
+}
+
+func (c *CachingBackend) store(pos spatial.BlockPosition, data []byte, err error) {
+	if err == nil || errors.Is(err, ErrBlockNotFound) {
+		c.cache.Add(pos, data)
+	}
+}
+
+func (c *CachingBackend) GetBlockData(ctx context.Context, pos spatial.BlockPosition) ([]byte, error) {
+	if data, cached, err := c.lookup(pos); cached {
+		return data, err
+	}
+
+	data, err := c.inner.GetBlockData(ctx, pos)
+	c.store(pos, data, err)
+
+	return data, err
+}
+
+func (c *CachingBackend) GetBlockDataBatch(ctx context.Context, positions []spatial.BlockPosition) ([][]byte, []error) {
+	data := make([][]byte, len(positions))
+	errs := make([]error, len(positions))
+
+	// Only fetch blocks that aren't cached yet
+	var missingIndices []int
+	var missingPositions []spatial.BlockPosition
+	for i, pos := range positions {
+		var cached bool
+		data[i], cached, errs[i] = c.lookup(pos)
+		if !cached {
+			missingIndices = append(missingIndices, i)
+			missingPositions = append(missingPositions, pos)
+		}
+	}
+
+	if len(missingPositions) == 0 {
+		return data, errs
+	}
+
+	fetchedData, fetchedErrs := c.inner.GetBlockDataBatch(ctx, missingPositions)
+	for j, i := range missingIndices {
+		data[i], errs[i] = fetchedData[j], fetchedErrs[j]
+		c.store(positions[i], data[i], errs[i])
+	}
+
+	return data, errs
+}
+
+func (c *CachingBackend) GetBlocksInRegion(ctx context.Context, min, max spatial.BlockPosition) (map[spatial.BlockPosition][]byte, error) {
+	return c.inner.GetBlocksInRegion(ctx, min, max)
+}
+
+func (c *CachingBackend) IterateBlocks(ctx context.Context) (BlockIterator, error) {
+	return c.inner.IterateBlocks(ctx)
+}
@@ -0,0 +1,92 @@
+package world
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/weqqr/panorama/pkg/spatial"
+)
+
+// ExportedNode is a node written by ExportNodes. X, Y and Z are world
+// coordinates of the node, the same as shown by Minetest: X points east, Y
+// points up and Z points north. The node at world position P is stored in the
+// block at floor(P/16), at offset P mod 16.
+type ExportedNode struct {
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Z      int    `json:"z"`
+	Name   string `json:"name"`
+	Param1 uint8  `json:"param1"`
+	Param2 uint8  `json:"param2"`
+}
+
+// ExportNodes writes all nodes inside region except air and ignore to out as
+// a JSON array of ExportedNode. Nodes are written block by block, in the order
+// scanRegion visits blocks, and within a block with X changing fastest and Z
+// slowest. The output is streamed.
+func (w *World) ExportNodes(ctx context.Context, region spatial.Region, out io.Writer) error {
+	writer := bufio.NewWriter(out)
+
+	if _, err := writer.WriteString("["); err != nil {
+		return err
+	}
+
+	first := true
+	err := w.scanRegion(ctx, region, func(blockPos spatial.BlockPosition, block *MapBlock) error {
+		for z := 0; z < spatial.BlockSize; z++ {
+			for y := 0; y < spatial.BlockSize; y++ {
+				for x := 0; x < spatial.BlockSize; x++ {
+					pos := blockPos.AddNode(spatial.NodePosition{X: x, Y: y, Z: z})
+					if !region.Intersects(pos.Region()) {
+						continue
+					}
+
+					node := block.GetNode(spatial.NodePosition{X: x, Y: y, Z: z})
+					name := block.ResolveName(node.ID)
+					if name == "air" || name == "ignore" {
+						continue
+					}
+
+					record, err := json.Marshal(ExportedNode{
+						X:      pos.X,
+						Y:      pos.Y,
+						Z:      pos.Z,
+						Name:   name,
+						Param1: node.Param1,
+						Param2: node.Param2,
+					})
+					if err != nil {
+						return err
+					}
+
+					separator := ",\n"
+					if first {
+						separator = "\n"
+						first = false
+					}
+
+					if _, err := writer.WriteString(separator); err != nil {
+						return err
+					}
+
+					if _, err := writer.Write(record); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := writer.WriteString("\n]\n"); err != nil {
+		return err
+	}
+
+	return writer.Flush()
+}
@@ -0,0 +1,103 @@
+package world
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/weqqr/panorama/pkg/spatial"
+)
+
+type LevelDBBackend struct {
+	db *leveldb.DB
+}
+
+func NewLevelDBBackend(path string) (*LevelDBBackend, error) {
+	db, err := leveldb.OpenFile(path, &opt.Options{
+		ErrorIfMissing: true,
+		ReadOnly:       true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("`%v` is not a valid LevelDB map: %w", path, err)
+	}
+
+	return &LevelDBBackend{
+		db: db,
+	}, nil
+}
+
+func (l *LevelDBBackend) Close() {
+	l.db.Close()
+}
+
+func (l *LevelDBBackend) GetBlockData(ctx context.Context, pos spatial.BlockPosition) ([]byte, error) {
+	// LevelDB lookups are local and can't be interrupted, so only check for
+	// cancellation up front
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Minetest stores keys as decimal strings rather than raw integers
+	key := strconv.FormatInt(spatial.BlockPosToInteger(pos), 10)
+
+	data, err := l.db.Get([]byte(key), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return nil, ErrBlockNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (l *LevelDBBackend) GetBlockDataBatch(ctx context.Context, positions []spatial.BlockPosition) ([][]byte, []error) {
+	return GetBlockDataEach(ctx, l, positions)
+}
+
+func (l *LevelDBBackend) GetBlocksInRegion(ctx context.Context, min, max spatial.BlockPosition) (map[spatial.BlockPosition][]byte, error) {
+	return GetBlocksInRegionEach(ctx, l, min, max)
+}
+
+type levelDBBlockIterator struct {
+	inner iterator.Iterator
+	pos   spatial.BlockPosition
+	err   error
+}
+
+func (it *levelDBBlockIterator) Next() bool {
+	if !it.inner.Next() {
+		return false
+	}
+
+	var key int64
+	key, it.err = strconv.ParseInt(string(it.inner.Key()), 10, 64)
+	it.pos = spatial.IntegerToBlockPos(key)
+	return it.err == nil
+}
+
+func (it *levelDBBlockIterator) Position() spatial.BlockPosition {
+	return it.pos
+}
+
+func (it *levelDBBlockIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.inner.Error()
+}
+
+func (it *levelDBBlockIterator) Close() {
+	it.inner.Release()
+}
+
+func (l *LevelDBBackend) IterateBlocks(ctx context.Context) (BlockIterator, error) {
+	return &levelDBBlockIterator{
+		inner: l.db.NewIterator(nil, nil),
+	}, nil
+}
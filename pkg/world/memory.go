@@ -0,0 +1,90 @@
+package world
+
+import (
+	"context"
+	"sync"
+
+	"github.com/weqqr/panorama/pkg/spatial"
+)
+
+// MemoryBackend keeps block data in memory. It's useful for testing code that
+// depends on World without setting up a database.
+type MemoryBackend struct {
+	mutex  sync.RWMutex
+	blocks map[spatial.BlockPosition][]byte
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		blocks: make(map[spatial.BlockPosition][]byte),
+	}
+}
+
+// SetBlock stores serialized block data at pos
+func (m *MemoryBackend) SetBlock(pos spatial.BlockPosition, data []byte) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.blocks[pos] = data
+}
+
+func (m *MemoryBackend) Close() {}
+
+func (m *MemoryBackend) GetBlockData(ctx context.Context, pos spatial.BlockPosition) ([]byte, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	data, ok := m.blocks[pos]
+	if !ok {
+		return nil, ErrBlockNotFound
+	}
+
+	return data, nil
+}
+
+func (m *MemoryBackend) GetBlockDataBatch(ctx context.Context, positions []spatial.BlockPosition) ([][]byte, []error) {
+	return GetBlockDataEach(ctx, m, positions)
+}
+
+func (m *MemoryBackend) GetBlocksInRegion(ctx context.Context, min, max spatial.BlockPosition) (map[spatial.BlockPosition][]byte, error) {
+	return GetBlocksInRegionEach(ctx, m, min, max)
+}
+
+type memoryBlockIterator struct {
+	positions []spatial.BlockPosition
+	pos       spatial.BlockPosition
+}
+
+func (it *memoryBlockIterator) Next() bool {
+	if len(it.positions) == 0 {
+		return false
+	}
+
+	it.pos = it.positions[0]
+	it.positions = it.positions[1:]
+	return true
+}
+
+func (it *memoryBlockIterator) Position() spatial.BlockPosition {
+	return it.pos
+}
+
+func (it *memoryBlockIterator) Err() error {
+	return nil
+}
+
+func (it *memoryBlockIterator) Close() {}
+
+func (m *MemoryBackend) IterateBlocks(ctx context.Context) (BlockIterator, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	positions := make([]spatial.BlockPosition, 0, len(m.blocks))
+	for pos := range m.blocks {
+		positions = append(positions, pos)
+	}
+
+	return &memoryBlockIterator{
+		positions: positions,
+	}, nil
+}
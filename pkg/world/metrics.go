@@ -0,0 +1,137 @@
+package world
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics receives events about reading blocks, which shows whether time is
+// spent waiting for the backend or decoding blocks
+type Metrics interface {
+	// BlockFetched is called for every block requested from the backend.
+	// size is the length of its data, or 0 if the block doesn't exist.
+	BlockFetched(size int, found bool)
+	// CacheHit and CacheMiss are called for every lookup in a block cache
+	CacheHit()
+	CacheMiss()
+	// BlockDecoded is called after a block is decoded, successfully or not
+	BlockDecoded(duration time.Duration)
+}
+
+// metrics is nil until SetMetrics is called, and nothing is measured while
+// it's nil
+var metrics Metrics
+
+// SetMetrics makes worlds, caching backends and the block decoder report to m.
+// It must be called before any blocks are read. nil disables metrics.
+func SetMetrics(m Metrics) {
+	metrics = m
+}
+
+func recordFetch(data []byte, err error) {
+	if metrics != nil && (err == nil || errors.Is(err, ErrBlockNotFound)) {
+		metrics.BlockFetched(len(data), err == nil)
+	}
+}
+
+func recordCacheLookup(hit bool) {
+	if metrics == nil {
+		return
+	}
+
+	if hit {
+		metrics.CacheHit()
+	} else {
+		metrics.CacheMiss()
+	}
+}
+
+// Counters is a Metrics implementation keeping running totals. It's safe for
+// concurrent use.
+type Counters struct {
+	fetches       int64
+	missingBlocks int64
+	bytesRead     int64
+	cacheHits     int64
+	cacheMisses   int64
+	decodes       int64
+	decodeTime    int64
+}
+
+func (c *Counters) BlockFetched(size int, found bool) {
+	atomic.AddInt64(&c.fetches, 1)
+	atomic.AddInt64(&c.bytesRead, int64(size))
+	if !found {
+		atomic.AddInt64(&c.missingBlocks, 1)
+	}
+}
+
+func (c *Counters) CacheHit() {
+	atomic.AddInt64(&c.cacheHits, 1)
+}
+
+func (c *Counters) CacheMiss() {
+	atomic.AddInt64(&c.cacheMisses, 1)
+}
+
+func (c *Counters) BlockDecoded(duration time.Duration) {
+	atomic.AddInt64(&c.decodes, 1)
+	atomic.AddInt64(&c.decodeTime, int64(duration))
+}
+
+// CounterTotals is a snapshot of Counters
+type CounterTotals struct {
+	Fetches       int64
+	MissingBlocks int64
+	BytesRead     int64
+	CacheHits     int64
+	CacheMisses   int64
+	Decodes       int64
+	DecodeTime    time.Duration
+}
+
+func (c *Counters) Totals() CounterTotals {
+	return CounterTotals{
+		Fetches:       atomic.LoadInt64(&c.fetches),
+		MissingBlocks: atomic.LoadInt64(&c.missingBlocks),
+		BytesRead:     atomic.LoadInt64(&c.bytesRead),
+		CacheHits:     atomic.LoadInt64(&c.cacheHits),
+		CacheMisses:   atomic.LoadInt64(&c.cacheMisses),
+		Decodes:       atomic.LoadInt64(&c.decodes),
+		DecodeTime:    time.Duration(atomic.LoadInt64(&c.decodeTime)),
+	}
+}
+
+func (t CounterTotals) String() string {
+	return fmt.Sprintf("%v blocks fetched (%v missing, %v bytes), %v cache hits, %v cache misses, %v blocks decoded in %v",
+		t.Fetches, t.MissingBlocks, t.BytesRead, t.CacheHits, t.CacheMisses, t.Decodes, t.DecodeTime)
+}
+
+// WritePrometheus writes the totals in the Prometheus text exposition format
+func (c *Counters) WritePrometheus(out io.Writer) error {
+	totals := c.Totals()
+
+	for _, counter := range []struct {
+		name  string
+		help  string
+		value interface{}
+	}{
+		{"panorama_block_fetches_total", "Blocks requested from the backend.", totals.Fetches},
+		{"panorama_block_missing_total", "Requested blocks that don't exist.", totals.MissingBlocks},
+		{"panorama_block_read_bytes_total", "Bytes of block data read from the backend.", totals.BytesRead},
+		{"panorama_block_cache_hits_total", "Block cache lookups that found the block.", totals.CacheHits},
+		{"panorama_block_cache_misses_total", "Block cache lookups that didn't find the block.", totals.CacheMisses},
+		{"panorama_block_decodes_total", "Blocks decoded.", totals.Decodes},
+		{"panorama_block_decode_seconds_total", "Time spent decoding blocks.", totals.DecodeTime.Seconds()},
+	} {
+		_, err := fmt.Fprintf(out, "# HELP %v %v\n# TYPE %v counter\n%v %v\n", counter.name, counter.help, counter.name, counter.name, counter.value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,250 @@
+package world
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/weqqr/panorama/pkg/spatial"
+)
+
+// PostgresSchema describes where blocks are stored in the database. Empty
+// fields take the values used by Minetest.
+type PostgresSchema struct {
+	// Table may be qualified with the schema name, e.g. `public.blocks`
+	Table      string `toml:"table"`
+	DataColumn string `toml:"data_column"`
+	XColumn    string `toml:"x_column"`
+	YColumn    string `toml:"y_column"`
+	ZColumn    string `toml:"z_column"`
+
+	// PositionColumn selects the legacy layout where block position is
+	// stored in a single BIGINT column encoded the same way as in SQLite.
+	// If set, XColumn, YColumn and ZColumn are ignored.
+	PositionColumn string `toml:"position_column"`
+}
+
+func (s PostgresSchema) withDefaults() PostgresSchema {
+	setDefault := func(field *string, value string) {
+		if *field == "" {
+			*field = value
+		}
+	}
+
+	setDefault(&s.Table, "blocks")
+	setDefault(&s.DataColumn, "data")
+	setDefault(&s.XColumn, "posx")
+	setDefault(&s.YColumn, "posy")
+	setDefault(&s.ZColumn, "posz")
+
+	return s
+}
+
+func quoteIdentifier(name string) string {
+	return pgx.Identifier(strings.Split(name, ".")).Sanitize()
+}
+
+type PostgresBackend struct {
+	conn *pgxpool.Pool
+
+	singleColumn    bool
+	getBlockQuery   string
+	getRegionQuery  string
+	listBlocksQuery string
+}
+
+// NewPostgresBackend creates a connection pool for the database identified by
+// dsn. If maxConns is zero, pgxpool's default pool size is used.
+func NewPostgresBackend(dsn string, maxConns int, schema PostgresSchema) (*PostgresBackend, error) {
+	config, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if maxConns > 0 {
+		config.MaxConns = int32(maxConns)
+	}
+
+	conn, err := pgxpool.ConnectConfig(context.Background(), config)
+	if err != nil {
+		return nil, err
+	}
+
+	schema = schema.withDefaults()
+	table := quoteIdentifier(schema.Table)
+	data := quoteIdentifier(schema.DataColumn)
+
+	if schema.PositionColumn != "" {
+		pos := quoteIdentifier(schema.PositionColumn)
+
+		return &PostgresBackend{
+			conn: conn,
+
+			singleColumn:    true,
+			getBlockQuery:   fmt.Sprintf("SELECT %v FROM %v WHERE %v=$1", data, table, pos),
+			getRegionQuery:  fmt.Sprintf("SELECT %v, %v FROM %v WHERE %v BETWEEN $1 AND $2", pos, data, table, pos),
+			listBlocksQuery: fmt.Sprintf("SELECT %v FROM %v", pos, table),
+		}, nil
+	}
+
+	x := quoteIdentifier(schema.XColumn)
+	y := quoteIdentifier(schema.YColumn)
+	z := quoteIdentifier(schema.ZColumn)
+
+	return &PostgresBackend{
+		conn: conn,
+
+		getBlockQuery: fmt.Sprintf("SELECT %v FROM %v WHERE %v=$1 and %v=$2 and %v=$3", data, table, x, y, z),
+		getRegionQuery: fmt.Sprintf("SELECT %v, %v, %v, %v FROM %v WHERE %v BETWEEN $1 AND $2 AND %v BETWEEN $3 AND $4 AND %v BETWEEN $5 AND $6",
+			x, y, z, data, table, x, y, z),
+		listBlocksQuery: fmt.Sprintf("SELECT %v, %v, %v FROM %v", x, y, z, table),
+	}, nil
+}
+
+// queryArgs returns getBlockQuery arguments identifying pos
+func (p *PostgresBackend) queryArgs(pos spatial.BlockPosition) []interface{} {
+	if p.singleColumn {
+		return []interface{}{spatial.BlockPosToInteger(pos)}
+	}
+
+	return []interface{}{pos.X, pos.Y, pos.Z}
+}
+
+func (p *PostgresBackend) Close() {
+	p.conn.Close()
+}
+
+func (p *PostgresBackend) GetBlockData(ctx context.Context, pos spatial.BlockPosition) ([]byte, error) {
+	var data []byte
+	err := p.conn.QueryRow(ctx, p.getBlockQuery, p.queryArgs(pos)...).Scan(&data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrBlockNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (p *PostgresBackend) GetBlockDataBatch(ctx context.Context, positions []spatial.BlockPosition) ([][]byte, []error) {
+	data := make([][]byte, len(positions))
+	errs := make([]error, len(positions))
+
+	batch := &pgx.Batch{}
+	for _, pos := range positions {
+		batch.Queue(p.getBlockQuery, p.queryArgs(pos)...)
+	}
+
+	results := p.conn.SendBatch(ctx, batch)
+	defer results.Close()
+
+	for i := range positions {
+		err := results.QueryRow().Scan(&data[i])
+		if errors.Is(err, pgx.ErrNoRows) {
+			err = ErrBlockNotFound
+		}
+
+		errs[i] = err
+	}
+
+	return data, errs
+}
+
+func (p *PostgresBackend) GetBlocksInRegion(ctx context.Context, min, max spatial.BlockPosition) (map[spatial.BlockPosition][]byte, error) {
+	var rows pgx.Rows
+	var err error
+	if p.singleColumn {
+		// Every position inside the region is encoded into a value between
+		// encoded min and max, but not the other way around, so the results
+		// need to be filtered
+		rows, err = p.conn.Query(ctx, p.getRegionQuery, spatial.BlockPosToInteger(min), spatial.BlockPosToInteger(max))
+	} else {
+		rows, err = p.conn.Query(ctx, p.getRegionQuery, min.X, max.X, min.Y, max.Y, min.Z, max.Z)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blocks := make(map[spatial.BlockPosition][]byte)
+	for rows.Next() {
+		var pos spatial.BlockPosition
+		var data []byte
+
+		if p.singleColumn {
+			var key int64
+			err = rows.Scan(&key, &data)
+			pos = spatial.IntegerToBlockPos(key)
+		} else {
+			err = rows.Scan(&pos.X, &pos.Y, &pos.Z, &data)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if blockInRange(pos, min, max) {
+			blocks[pos] = data
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return blocks, nil
+}
+
+type postgresBlockIterator struct {
+	rows         pgx.Rows
+	singleColumn bool
+	pos          spatial.BlockPosition
+	err          error
+}
+
+func (it *postgresBlockIterator) Next() bool {
+	if !it.rows.Next() {
+		return false
+	}
+
+	if it.singleColumn {
+		var key int64
+		it.err = it.rows.Scan(&key)
+		it.pos = spatial.IntegerToBlockPos(key)
+	} else {
+		it.err = it.rows.Scan(&it.pos.X, &it.pos.Y, &it.pos.Z)
+	}
+
+	return it.err == nil
+}
+
+func (it *postgresBlockIterator) Position() spatial.BlockPosition {
+	return it.pos
+}
+
+func (it *postgresBlockIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.rows.Err()
+}
+
+func (it *postgresBlockIterator) Close() {
+	it.rows.Close()
+}
+
+func (p *PostgresBackend) IterateBlocks(ctx context.Context) (BlockIterator, error) {
+	rows, err := p.conn.Query(ctx, p.listBlocksQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	return &postgresBlockIterator{
+		rows:         rows,
+		singleColumn: p.singleColumn,
+	}, nil
+}
@@ -0,0 +1,244 @@
+package world
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/weqqr/panorama/pkg/spatial"
+)
+
+type RedisBackend struct {
+	pool *redis.Pool
+	hash string
+}
+
+// redisIdleTimeout is how long unused connections are kept open
+const redisIdleTimeout = 5 * time.Minute
+
+// NewRedisBackend connects to the Redis server at address, given either as
+// `host:port` or as a `redis://` URL. password is only needed if address
+// doesn't contain one. Blocks are read from the hash named by hash
+// (`redis_hash` in world.mt). Up to maxIdle connections are kept open
+// between requests, which should be at least the number of workers. If it's
+// 0, one connection per CPU core is kept.
+func NewRedisBackend(address, password, hash string, maxIdle int) (*RedisBackend, error) {
+	if maxIdle <= 0 {
+		maxIdle = runtime.NumCPU()
+	}
+
+	pool := &redis.Pool{
+		MaxIdle:     maxIdle,
+		IdleTimeout: redisIdleTimeout,
+		Dial: func() (redis.Conn, error) {
+			var options []redis.DialOption
+			if password != "" {
+				options = append(options, redis.DialPassword(password))
+			}
+
+			if strings.HasPrefix(address, "redis://") || strings.HasPrefix(address, "rediss://") {
+				return redis.DialURL(address, options...)
+			}
+			return redis.Dial("tcp", address, options...)
+		},
+	}
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("PING")
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	return &RedisBackend{
+		pool: pool,
+		hash: hash,
+	}, nil
+}
+
+func (r *RedisBackend) Close() {
+	r.pool.Close()
+}
+
+func (r *RedisBackend) GetBlockData(ctx context.Context, pos spatial.BlockPosition) ([]byte, error) {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	key := strconv.FormatInt(spatial.BlockPosToInteger(pos), 10)
+
+	data, err := redis.Bytes(redis.DoContext(conn, ctx, "HGET", r.hash, key))
+	if errors.Is(err, redis.ErrNil) {
+		return nil, ErrBlockNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (r *RedisBackend) GetBlockDataBatch(ctx context.Context, positions []spatial.BlockPosition) ([][]byte, []error) {
+	data := make([][]byte, len(positions))
+	errs := make([]error, len(positions))
+
+	fail := func(err error) ([][]byte, []error) {
+		for i := range errs {
+			errs[i] = err
+		}
+		return data, errs
+	}
+
+	if len(positions) == 0 {
+		return data, errs
+	}
+
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return fail(err)
+	}
+	defer conn.Close()
+
+	args := make([]interface{}, 0, len(positions)+1)
+	args = append(args, r.hash)
+	for _, pos := range positions {
+		args = append(args, strconv.FormatInt(spatial.BlockPosToInteger(pos), 10))
+	}
+
+	values, err := redis.ByteSlices(redis.DoContext(conn, ctx, "HMGET", args...))
+	if err != nil {
+		return fail(err)
+	}
+
+	for i := range positions {
+		data[i] = values[i]
+		if data[i] == nil {
+			errs[i] = ErrBlockNotFound
+		}
+	}
+
+	return data, errs
+}
+
+func (r *RedisBackend) GetBlocksInRegion(ctx context.Context, min, max spatial.BlockPosition) (map[spatial.BlockPosition][]byte, error) {
+	return GetBlocksInRegionEach(ctx, r, min, max)
+}
+
+// redisScanCount is the number of keys requested from Redis at once while
+// iterating blocks
+const redisScanCount = 1000
+
+// redisBlockIterator lists the hash with HSCAN, holding a connection until
+// it's closed
+type redisBlockIterator struct {
+	ctx  context.Context
+	conn redis.Conn
+	hash string
+	// noValues is cleared if the server doesn't support HSCAN NOVALUES,
+	// which was added in Redis 7.4
+	noValues bool
+	cursor   string
+	done     bool
+
+	keys [][]byte
+	pos  spatial.BlockPosition
+	err  error
+}
+
+// scan fetches the next batch of keys
+func (it *redisBlockIterator) scan() {
+	args := []interface{}{it.hash, it.cursor, "COUNT", redisScanCount}
+	if it.noValues {
+		args = append(args, "NOVALUES")
+	}
+
+	reply, err := redis.Values(redis.DoContext(it.conn, it.ctx, "HSCAN", args...))
+
+	var serverErr redis.Error
+	if it.noValues && errors.As(err, &serverErr) {
+		it.noValues = false
+		it.scan()
+		return
+	}
+
+	if err != nil {
+		it.err = err
+		return
+	}
+
+	var items [][]byte
+	if _, err := redis.Scan(reply, &it.cursor, &items); err != nil {
+		it.err = err
+		return
+	}
+
+	if it.noValues {
+		it.keys = items
+	} else {
+		// Keys alternate with values, which aren't needed
+		it.keys = it.keys[:0]
+		for i := 0; i < len(items); i += 2 {
+			it.keys = append(it.keys, items[i])
+		}
+	}
+
+	it.done = it.cursor == "0"
+}
+
+func (it *redisBlockIterator) Next() bool {
+	for it.err == nil && len(it.keys) == 0 {
+		if it.done {
+			return false
+		}
+		it.scan()
+	}
+
+	if it.err != nil {
+		return false
+	}
+
+	var key int64
+	key, it.err = strconv.ParseInt(string(it.keys[0]), 10, 64)
+	it.pos = spatial.IntegerToBlockPos(key)
+	it.keys = it.keys[1:]
+	return it.err == nil
+}
+
+func (it *redisBlockIterator) Position() spatial.BlockPosition {
+	return it.pos
+}
+
+func (it *redisBlockIterator) Err() error {
+	return it.err
+}
+
+func (it *redisBlockIterator) Close() {
+	it.conn.Close()
+}
+
+// IterateBlocks lists keys of the hash in batches with HSCAN, so they aren't
+// all held in memory at once. Like any Redis SCAN, it may list a block twice
+// if the hash is resized while it's being iterated.
+func (r *RedisBackend) IterateBlocks(ctx context.Context) (BlockIterator, error) {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &redisBlockIterator{
+		ctx:      ctx,
+		conn:     conn,
+		hash:     r.hash,
+		noValues: true,
+		cursor:   "0",
+	}, nil
+}
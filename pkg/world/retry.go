@@ -0,0 +1,162 @@
+package world
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/weqqr/panorama/pkg/logging"
+	"github.com/weqqr/panorama/pkg/spatial"
+)
+
+// RetryPolicy describes how RetryingBackend retries failed requests
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a request is made before its error
+	// is returned. Values below 2 disable retrying.
+	MaxAttempts int
+	// InitialDelay is the time to wait before the first retry. It doubles
+	// after every failed retry, up to MaxDelay, or without a limit if MaxDelay
+	// is 0.
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+}
+
+// delay returns the time to wait after the given failed attempt, starting
+// from 1
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	delay := p.InitialDelay
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	return delay
+}
+
+// RetryingBackend wraps another backend and retries requests that fail,
+// waiting longer after each failure. Missing blocks and cancelled requests
+// aren't retried, every other error is assumed to be transient, such as a
+// dropped database connection.
+type RetryingBackend struct {
+	inner  Backend
+	policy RetryPolicy
+}
+
+func NewRetryingBackend(inner Backend, policy RetryPolicy) *RetryingBackend {
+	return &RetryingBackend{
+		inner:  inner,
+		policy: policy,
+	}
+}
+
+func (r *RetryingBackend) Close() {
+	r.inner.Close()
+}
+
+func isTransient(err error) bool {
+	return err != nil &&
+		!errors.Is(err, ErrBlockNotFound) &&
+		!errors.Is(err, context.Canceled) &&
+		!errors.Is(err, context.DeadlineExceeded)
+}
+
+// retry calls fetch until it succeeds with a non-transient result or the
+// attempts run out, and returns the last error
+func (r *RetryingBackend) retry(ctx context.Context, fetch func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fetch()
+		if !isTransient(err) || attempt >= r.policy.MaxAttempts {
+			return err
+		}
+
+		delay := r.policy.delay(attempt)
+		logging.Warnf("attempt %v of %v failed, retrying in %v: %v", attempt, r.policy.MaxAttempts, delay, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+func (r *RetryingBackend) GetBlockData(ctx context.Context, pos spatial.BlockPosition) ([]byte, error) {
+	var data []byte
+	err := r.retry(ctx, func() error {
+		var err error
+		data, err = r.inner.GetBlockData(ctx, pos)
+		return err
+	})
+
+	return data, err
+}
+
+func (r *RetryingBackend) GetBlockDataBatch(ctx context.Context, positions []spatial.BlockPosition) ([][]byte, []error) {
+	data, errs := r.inner.GetBlockDataBatch(ctx, positions)
+
+	// Only fetch again the blocks that failed
+	for attempt := 1; attempt < r.policy.MaxAttempts; attempt++ {
+		var failedIndices []int
+		var failedPositions []spatial.BlockPosition
+		var lastErr error
+		for i, err := range errs {
+			if isTransient(err) {
+				failedIndices = append(failedIndices, i)
+				failedPositions = append(failedPositions, positions[i])
+				lastErr = err
+			}
+		}
+
+		if len(failedPositions) == 0 {
+			break
+		}
+
+		delay := r.policy.delay(attempt)
+		logging.Warnf("attempt %v of %v failed for %v blocks, retrying in %v: %v", attempt, r.policy.MaxAttempts, len(failedPositions), delay, lastErr)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return data, errs
+		}
+
+		fetchedData, fetchedErrs := r.inner.GetBlockDataBatch(ctx, failedPositions)
+		for j, i := range failedIndices {
+			data[i], errs[i] = fetchedData[j], fetchedErrs[j]
+		}
+	}
+
+	return data, errs
+}
+
+func (r *RetryingBackend) GetBlocksInRegion(ctx context.Context, min, max spatial.BlockPosition) (map[spatial.BlockPosition][]byte, error) {
+	var blocks map[spatial.BlockPosition][]byte
+	err := r.retry(ctx, func() error {
+		var err error
+		blocks, err = r.inner.GetBlocksInRegion(ctx, min, max)
+		return err
+	})
+
+	return blocks, err
+}
+
+// IterateBlocks retries starting the iteration. Errors in the middle of it
+// are returned by the iterator as usual, since positions it has already
+// yielded can't be taken back.
+func (r *RetryingBackend) IterateBlocks(ctx context.Context) (BlockIterator, error) {
+	var it BlockIterator
+	err := r.retry(ctx, func() error {
+		var err error
+		it, err = r.inner.IterateBlocks(ctx)
+		return err
+	})
+
+	return it, err
+}
@@ -0,0 +1,29 @@
+package world
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	for _, test := range []struct {
+		policy  RetryPolicy
+		attempt int
+		want    time.Duration
+	}{
+		{RetryPolicy{InitialDelay: 100 * time.Millisecond, MaxDelay: time.Second}, 1, 100 * time.Millisecond},
+		{RetryPolicy{InitialDelay: 100 * time.Millisecond, MaxDelay: time.Second}, 2, 200 * time.Millisecond},
+		{RetryPolicy{InitialDelay: 100 * time.Millisecond, MaxDelay: time.Second}, 4, 800 * time.Millisecond},
+		{RetryPolicy{InitialDelay: 100 * time.Millisecond, MaxDelay: time.Second}, 5, time.Second},
+		{RetryPolicy{InitialDelay: 100 * time.Millisecond, MaxDelay: time.Second}, 20, time.Second},
+		{RetryPolicy{InitialDelay: 100 * time.Millisecond}, 1, 100 * time.Millisecond},
+		{RetryPolicy{InitialDelay: 100 * time.Millisecond}, 3, 400 * time.Millisecond},
+		{RetryPolicy{InitialDelay: 100 * time.Millisecond}, 5, 1600 * time.Millisecond},
+		{RetryPolicy{InitialDelay: time.Second, MaxDelay: 100 * time.Millisecond}, 1, 100 * time.Millisecond},
+		{RetryPolicy{}, 3, 0},
+	} {
+		if got := test.policy.delay(test.attempt); got != test.want {
+			t.Errorf("%+v.delay(%v) = %v, want %v", test.policy, test.attempt, got, test.want)
+		}
+	}
+}
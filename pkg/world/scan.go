@@ -0,0 +1,149 @@
+package world
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/weqqr/panorama/pkg/spatial"
+)
+
+// scanBatchSize is the number of blocks fetched at once by scanRegion
+const scanBatchSize = 256
+
+// maxLayeredScanBlocks limits the number of block positions in regions that
+// scanRegion fetches layer by layer
+const maxLayeredScanBlocks = 1 << 20
+
+// scanRegion calls fn for every stored block intersecting region. Blocks are
+// decoded one by one without being cached, and the block passed to fn is
+// reused for the next one, so fn must not keep it.
+//
+// Blocks are fetched one layer of constant Z at a time, ordered by Z, Y and X,
+// so the cost doesn't depend on the size of the world. Regions spanning more
+// than maxLayeredScanBlocks positions likely cover most of the world, so all
+// stored blocks are streamed from the backend instead and filtered, in the
+// order the backend lists them. That's much cheaper than looking up every
+// position for backends without range queries.
+func (w *World) scanRegion(ctx context.Context, region spatial.Region, fn func(pos spatial.BlockPosition, block *MapBlock) error) error {
+	min := spatial.NodeToBlock(spatial.NodePosition{
+		X: region.XBounds.Min,
+		Y: region.YBounds.Min,
+		Z: region.ZBounds.Min,
+	})
+	max := spatial.NodeToBlock(spatial.NodePosition{
+		X: region.XBounds.Max,
+		Y: region.YBounds.Max,
+		Z: region.ZBounds.Max,
+	})
+
+	if min.X > max.X || min.Y > max.Y || min.Z > max.Z {
+		return nil
+	}
+
+	blocks := int64(max.X-min.X+1) * int64(max.Y-min.Y+1) * int64(max.Z-min.Z+1)
+	if blocks <= maxLayeredScanBlocks {
+		return w.scanLayers(ctx, min, max, fn)
+	}
+
+	return w.scanAll(ctx, region, fn)
+}
+
+// scanLayers implements scanRegion for blocks between min and max
+func (w *World) scanLayers(ctx context.Context, min, max spatial.BlockPosition, fn func(pos spatial.BlockPosition, block *MapBlock) error) error {
+	block := &MapBlock{}
+	for z := min.Z; z <= max.Z; z++ {
+		layerMin := spatial.BlockPosition{X: min.X, Y: min.Y, Z: z}
+		layerMax := spatial.BlockPosition{X: max.X, Y: max.Y, Z: z}
+
+		layer, err := w.backend.GetBlocksInRegion(ctx, layerMin, layerMax)
+		if err != nil {
+			return err
+		}
+
+		positions := make([]spatial.BlockPosition, 0, len(layer))
+		for pos := range layer {
+			positions = append(positions, pos)
+		}
+		sort.Slice(positions, func(i, j int) bool {
+			if positions[i].Y != positions[j].Y {
+				return positions[i].Y < positions[j].Y
+			}
+			return positions[i].X < positions[j].X
+		})
+
+		for _, pos := range positions {
+			recordFetch(layer[pos], nil)
+
+			if err := DecodeMapBlockInto(layer[pos], block); err != nil {
+				return fmt.Errorf("decoding block %v: %w", pos, err)
+			}
+
+			if err := fn(pos, block); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// scanAll implements scanRegion by filtering all blocks of the world
+func (w *World) scanAll(ctx context.Context, region spatial.Region, fn func(pos spatial.BlockPosition, block *MapBlock) error) error {
+	it, err := w.backend.IterateBlocks(ctx)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+
+	block := &MapBlock{}
+	batch := make([]spatial.BlockPosition, 0, scanBatchSize)
+
+	scanBatch := func() error {
+		data, errs := w.backend.GetBlockDataBatch(ctx, batch)
+		for i, pos := range batch {
+			recordFetch(data[i], errs[i])
+
+			// The block may have been deleted after it was listed
+			if errors.Is(errs[i], ErrBlockNotFound) {
+				continue
+			}
+
+			if errs[i] != nil {
+				return errs[i]
+			}
+
+			if err := DecodeMapBlockInto(data[i], block); err != nil {
+				return fmt.Errorf("decoding block %v: %w", pos, err)
+			}
+
+			if err := fn(pos, block); err != nil {
+				return err
+			}
+		}
+
+		batch = batch[:0]
+		return nil
+	}
+
+	for it.Next() {
+		pos := it.Position()
+		if !pos.Region().Intersects(region) {
+			continue
+		}
+
+		batch = append(batch, pos)
+		if len(batch) == scanBatchSize {
+			if err := scanBatch(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := it.Err(); err != nil {
+		return err
+	}
+
+	return scanBatch()
+}
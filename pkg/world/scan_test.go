@@ -0,0 +1,92 @@
+package world
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/weqqr/panorama/pkg/spatial"
+)
+
+// noIterationBackend fails if all blocks of the world are listed
+type noIterationBackend struct {
+	*MemoryBackend
+}
+
+func (b noIterationBackend) IterateBlocks(ctx context.Context) (BlockIterator, error) {
+	return nil, errors.New("world is iterated")
+}
+
+func TestScanRegion(t *testing.T) {
+	backend := NewMemoryBackend()
+	data := readTestBlock(t, "block_v29.bin")
+	for _, pos := range []spatial.BlockPosition{
+		{X: 0, Y: 0, Z: 0},
+		{X: -1, Y: 0, Z: 0},
+		{X: 0, Y: 0, Z: 1},
+		{X: 5, Y: 5, Z: 5},
+	} {
+		backend.SetBlock(pos, data)
+	}
+	w := NewWorldWithBackend(noIterationBackend{backend})
+
+	region := spatial.Region{
+		XBounds: spatial.Bounds{Min: -8, Max: 15},
+		YBounds: spatial.Bounds{Min: 0, Max: 15},
+		ZBounds: spatial.Bounds{Min: 0, Max: 20},
+	}
+
+	var visited []spatial.BlockPosition
+	err := w.scanRegion(context.Background(), region, func(pos spatial.BlockPosition, block *MapBlock) error {
+		visited = append(visited, pos)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []spatial.BlockPosition{{X: -1, Y: 0, Z: 0}, {X: 0, Y: 0, Z: 0}, {X: 0, Y: 0, Z: 1}}
+	if !reflect.DeepEqual(visited, want) {
+		t.Errorf("visited %v, want %v", visited, want)
+	}
+}
+
+func TestScanRegionLarge(t *testing.T) {
+	backend := NewMemoryBackend()
+	data := readTestBlock(t, "block_v29.bin")
+	backend.SetBlock(spatial.BlockPosition{X: 0, Y: 0, Z: 0}, data)
+	backend.SetBlock(spatial.BlockPosition{X: 100, Y: 0, Z: 0}, data)
+	w := NewWorldWithBackend(backend)
+
+	// Too large to query every position, so stored blocks are listed instead
+	region := spatial.Region{
+		XBounds: spatial.Bounds{Min: -30912, Max: 30927},
+		YBounds: spatial.Bounds{Min: -30912, Max: 30927},
+		ZBounds: spatial.Bounds{Min: -30912, Max: 30927},
+	}
+
+	small, err := w.CountNodes(context.Background(), spatial.Region{
+		XBounds: spatial.Bounds{Min: 0, Max: 15},
+		YBounds: spatial.Bounds{Min: 0, Max: 15},
+		ZBounds: spatial.Bounds{Min: 0, Max: 15},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(small) == 0 {
+		t.Fatal("no nodes counted in a single block")
+	}
+
+	large, err := w.CountNodes(context.Background(), region)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, count := range small {
+		if large[name] != 2*count {
+			t.Errorf("counted %v of %v, want %v", large[name], name, 2*count)
+		}
+	}
+}
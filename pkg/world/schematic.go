@@ -0,0 +1,181 @@
+package world
+
+import (
+	"bufio"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/weqqr/panorama/pkg/spatial"
+)
+
+const (
+	schematicSignature = "MTSM"
+	schematicVersion   = 4
+
+	// schematicProbAlways makes nodes and slices of schematics always placed
+	schematicProbAlways = 0x7F
+)
+
+// ExportSchematic writes nodes inside region to out as a Minetest schematic
+// (.mts, version 4). The minimum corner of region becomes the origin of the
+// schematic. Nodes of missing blocks are saved as `ignore`, which Minetest
+// skips when placing the schematic. param1 of schematics holds placement
+// probability, so light levels are replaced with "always place", while
+// param2 is kept as it is.
+func (w *World) ExportSchematic(ctx context.Context, region spatial.Region, out io.Writer) error {
+	size := spatial.NodePosition{
+		X: region.XBounds.Max - region.XBounds.Min + 1,
+		Y: region.YBounds.Max - region.YBounds.Min + 1,
+		Z: region.ZBounds.Max - region.ZBounds.Min + 1,
+	}
+
+	if size.X <= 0 || size.Y <= 0 || size.Z <= 0 ||
+		size.X > math.MaxInt16 || size.Y > math.MaxInt16 || size.Z > math.MaxInt16 {
+		return fmt.Errorf("invalid schematic size: %v", size)
+	}
+
+	volume := size.X * size.Y * size.Z
+	content := make([]uint16, volume)
+	param2 := make([]uint8, volume)
+
+	names := []string{"ignore"}
+	ids := map[string]uint16{"ignore": 0}
+
+	min := spatial.NodeToBlock(spatial.NodePosition{
+		X: region.XBounds.Min,
+		Y: region.YBounds.Min,
+		Z: region.ZBounds.Min,
+	})
+	max := spatial.NodeToBlock(spatial.NodePosition{
+		X: region.XBounds.Max,
+		Y: region.YBounds.Max,
+		Z: region.ZBounds.Max,
+	})
+
+	// Fetch one layer of blocks at a time to limit the amount of data held
+	// by the backend
+	block := &MapBlock{}
+	for z := min.Z; z <= max.Z; z++ {
+		layerMin := spatial.BlockPosition{X: min.X, Y: min.Y, Z: z}
+		layerMax := spatial.BlockPosition{X: max.X, Y: max.Y, Z: z}
+
+		blocks, err := w.backend.GetBlocksInRegion(ctx, layerMin, layerMax)
+		if err != nil {
+			return err
+		}
+
+		for blockPos, data := range blocks {
+			recordFetch(data, nil)
+
+			if err := DecodeMapBlockInto(data, block); err != nil {
+				return fmt.Errorf("decoding block %v: %w", blockPos, err)
+			}
+
+			for nodeZ := 0; nodeZ < spatial.BlockSize; nodeZ++ {
+				for nodeY := 0; nodeY < spatial.BlockSize; nodeY++ {
+					for nodeX := 0; nodeX < spatial.BlockSize; nodeX++ {
+						nodePos := spatial.NodePosition{X: nodeX, Y: nodeY, Z: nodeZ}
+						pos := blockPos.AddNode(nodePos)
+						if !region.Intersects(pos.Region()) {
+							continue
+						}
+
+						node := block.GetNode(nodePos)
+						name := block.ResolveName(node.ID)
+
+						id, ok := ids[name]
+						if !ok {
+							if len(names) > math.MaxUint16 {
+								return fmt.Errorf("too many node names in schematic")
+							}
+
+							id = uint16(len(names))
+							ids[name] = id
+							names = append(names, name)
+						}
+
+						// Schematics are stored in Z, Y, X order with X
+						// changing fastest
+						offset := pos.Sub(spatial.NodePosition{
+							X: region.XBounds.Min,
+							Y: region.YBounds.Min,
+							Z: region.ZBounds.Min,
+						})
+						index := (offset.Z*size.Y+offset.Y)*size.X + offset.X
+
+						content[index] = id
+						param2[index] = node.Param2
+					}
+				}
+			}
+		}
+	}
+
+	return writeSchematic(out, size, names, content, param2)
+}
+
+func writeSchematic(out io.Writer, size spatial.NodePosition, names []string, content []uint16, param2 []uint8) error {
+	writer := bufio.NewWriter(out)
+
+	header := []interface{}{
+		[]byte(schematicSignature),
+		uint16(schematicVersion),
+		uint16(size.X), uint16(size.Y), uint16(size.Z),
+	}
+	for _, value := range header {
+		if err := binary.Write(writer, binary.BigEndian, value); err != nil {
+			return err
+		}
+	}
+
+	// Probabilities of Y slices
+	for y := 0; y < size.Y; y++ {
+		if err := writer.WriteByte(schematicProbAlways); err != nil {
+			return err
+		}
+	}
+
+	if err := binary.Write(writer, binary.BigEndian, uint16(len(names))); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if err := binary.Write(writer, binary.BigEndian, uint16(len(name))); err != nil {
+			return err
+		}
+
+		if _, err := writer.WriteString(name); err != nil {
+			return err
+		}
+	}
+
+	// Node data is compressed with zlib: content IDs of all nodes are
+	// followed by param1 and then param2
+	compressor := zlib.NewWriter(writer)
+	if err := binary.Write(compressor, binary.BigEndian, content); err != nil {
+		return err
+	}
+
+	param1 := make([]byte, len(content))
+	for i := range param1 {
+		param1[i] = schematicProbAlways
+	}
+
+	if _, err := compressor.Write(param1); err != nil {
+		return err
+	}
+
+	if _, err := compressor.Write(param2); err != nil {
+		return err
+	}
+
+	if err := compressor.Close(); err != nil {
+		return err
+	}
+
+	return writer.Flush()
+}
@@ -0,0 +1,127 @@
+package world
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/weqqr/panorama/pkg/spatial"
+)
+
+type SQLiteBackend struct {
+	db *sql.DB
+}
+
+func NewSQLiteBackend(path string) (*SQLiteBackend, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteBackend{
+		db: db,
+	}, nil
+}
+
+func (s *SQLiteBackend) Close() {
+	s.db.Close()
+}
+
+func (s *SQLiteBackend) GetBlockData(ctx context.Context, pos spatial.BlockPosition) ([]byte, error) {
+	var data []byte
+	err := s.db.QueryRowContext(ctx, "SELECT data FROM blocks WHERE pos=?", spatial.BlockPosToInteger(pos)).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrBlockNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (s *SQLiteBackend) GetBlockDataBatch(ctx context.Context, positions []spatial.BlockPosition) ([][]byte, []error) {
+	return GetBlockDataEach(ctx, s, positions)
+}
+
+// GetBlocksInRegion relies on the fact that every position inside the region
+// is encoded into a value between encoded min and max. The opposite isn't
+// true, so results are filtered afterwards.
+func (s *SQLiteBackend) GetBlocksInRegion(ctx context.Context, min, max spatial.BlockPosition) (map[spatial.BlockPosition][]byte, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT pos, data FROM blocks WHERE pos BETWEEN ? AND ?", spatial.BlockPosToInteger(min), spatial.BlockPosToInteger(max))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blocks := make(map[spatial.BlockPosition][]byte)
+	for rows.Next() {
+		var key int64
+		var data []byte
+		if err := rows.Scan(&key, &data); err != nil {
+			return nil, err
+		}
+
+		pos := spatial.IntegerToBlockPos(key)
+		if blockInRange(pos, min, max) {
+			blocks[pos] = data
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return blocks, nil
+}
+
+type sqliteBlockIterator struct {
+	rows *sql.Rows
+	pos  spatial.BlockPosition
+	err  error
+}
+
+func (it *sqliteBlockIterator) Next() bool {
+	if !it.rows.Next() {
+		return false
+	}
+
+	var key int64
+	it.err = it.rows.Scan(&key)
+	it.pos = spatial.IntegerToBlockPos(key)
+	return it.err == nil
+}
+
+func (it *sqliteBlockIterator) Position() spatial.BlockPosition {
+	return it.pos
+}
+
+func (it *sqliteBlockIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.rows.Err()
+}
+
+func (it *sqliteBlockIterator) Close() {
+	it.rows.Close()
+}
+
+func (s *SQLiteBackend) IterateBlocks(ctx context.Context) (BlockIterator, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT pos FROM blocks")
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqliteBlockIterator{
+		rows: rows,
+	}, nil
+}
@@ -0,0 +1,44 @@
+package world
+
+import (
+	"context"
+
+	"github.com/weqqr/panorama/pkg/spatial"
+)
+
+// CountNodes counts nodes of every name inside region, see scanRegion
+func (w *World) CountNodes(ctx context.Context, region spatial.Region) (map[string]int, error) {
+	counts := make(map[string]int)
+
+	err := w.scanRegion(ctx, region, func(pos spatial.BlockPosition, block *MapBlock) error {
+		countBlockNodes(counts, block, pos, region)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// countBlockNodes adds nodes of block at blockPos lying inside region to counts
+func countBlockNodes(counts map[string]int, block *MapBlock, blockPos spatial.BlockPosition, region spatial.Region) {
+	// Count content IDs first, so that names are looked up once per block
+	idCounts := make(map[uint16]int)
+	for z := 0; z < spatial.BlockSize; z++ {
+		for y := 0; y < spatial.BlockSize; y++ {
+			for x := 0; x < spatial.BlockSize; x++ {
+				pos := spatial.NodePosition{X: x, Y: y, Z: z}
+				if !region.Intersects(blockPos.AddNode(pos).Region()) {
+					continue
+				}
+
+				idCounts[block.GetNode(pos).ID]++
+			}
+		}
+	}
+
+	for id, count := range idCounts {
+		counts[block.ResolveName(id)] += count
+	}
+}
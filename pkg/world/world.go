@@ -3,49 +3,116 @@ package world
 import (
 	"context"
 	"errors"
+	"fmt"
 
 	lru "github.com/hashicorp/golang-lru"
-	"github.com/jackc/pgx/v4"
-	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/weqqr/panorama/pkg/spatial"
 )
 
+// ErrBlockNotFound is returned by backends and World when the requested block
+// doesn't exist in the map. Such blocks should be treated as empty.
+var ErrBlockNotFound = errors.New("block not found")
+
+// DecodeError is returned by World when data of a block can't be decoded.
+// Unlike failures of the backend, it only affects that block, so renderers
+// can skip it and draw the rest.
+type DecodeError struct {
+	Pos spatial.BlockPosition
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decoding block %v: %v", e.Pos, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// IsDecodeError reports whether err comes from decoding a block
+func IsDecodeError(err error) bool {
+	var decodeErr *DecodeError
+	return errors.As(err, &decodeErr)
+}
+
 type Backend interface {
-	GetBlockData(pos spatial.BlockPosition) ([]byte, error)
+	GetBlockData(ctx context.Context, pos spatial.BlockPosition) ([]byte, error)
+	// GetBlockDataBatch fetches multiple blocks at once. Results and errors
+	// are returned in the same order as positions.
+	GetBlockDataBatch(ctx context.Context, positions []spatial.BlockPosition) ([][]byte, []error)
+	// GetBlocksInRegion fetches all existing blocks with positions between
+	// min and max (inclusive)
+	GetBlocksInRegion(ctx context.Context, min, max spatial.BlockPosition) (map[spatial.BlockPosition][]byte, error)
+	// IterateBlocks returns an iterator over positions of all blocks stored
+	// in the map
+	IterateBlocks(ctx context.Context) (BlockIterator, error)
 	Close()
 }
 
-type PostgresBackend struct {
-	conn *pgxpool.Pool
+// BlockIterator yields block positions one at a time without loading all of
+// them into memory
+type BlockIterator interface {
+	// Next advances the iterator and reports whether a position is available
+	Next() bool
+	Position() spatial.BlockPosition
+	// Err returns the error that stopped the iteration, if any
+	Err() error
+	Close()
 }
 
-func NewPostgresBackend(dsn string) (*PostgresBackend, error) {
-	conn, err := pgxpool.Connect(context.Background(), dsn)
-	if err != nil {
-		return nil, err
-	}
+// GetBlockDataEach implements GetBlockDataBatch by fetching blocks one by one.
+// It's meant for backends that can't do better than that.
+func GetBlockDataEach(ctx context.Context, backend Backend, positions []spatial.BlockPosition) ([][]byte, []error) {
+	data := make([][]byte, len(positions))
+	errs := make([]error, len(positions))
 
-	return &PostgresBackend{
-		conn: conn,
-	}, nil
-}
+	for i, pos := range positions {
+		data[i], errs[i] = backend.GetBlockData(ctx, pos)
+	}
 
-func (p *PostgresBackend) Close() {
-	p.conn.Close()
+	return data, errs
 }
 
-func (p *PostgresBackend) GetBlockData(pos spatial.BlockPosition) ([]byte, error) {
-	var data []byte
-	err := p.conn.QueryRow(context.Background(), "SELECT data FROM blocks WHERE posx=$1 and posy=$2 and posz=$3", pos.X, pos.Y, pos.Z).Scan(&data)
-	if errors.Is(err, pgx.ErrNoRows) {
-		return nil, nil
+// GetBlocksInRegionEach implements GetBlocksInRegion using GetBlockDataBatch,
+// fetching one row of blocks along the X axis at a time. It's meant for
+// backends that can't do range queries. Like range queries, it returns no
+// blocks if min exceeds max along any axis.
+func GetBlocksInRegionEach(ctx context.Context, backend Backend, min, max spatial.BlockPosition) (map[spatial.BlockPosition][]byte, error) {
+	blocks := make(map[spatial.BlockPosition][]byte)
+	if min.X > max.X || min.Y > max.Y || min.Z > max.Z {
+		return blocks, nil
 	}
 
-	if err != nil {
-		return nil, err
+	row := make([]spatial.BlockPosition, 0, max.X-min.X+1)
+	for z := min.Z; z <= max.Z; z++ {
+		for y := min.Y; y <= max.Y; y++ {
+			row = row[:0]
+			for x := min.X; x <= max.X; x++ {
+				row = append(row, spatial.BlockPosition{X: x, Y: y, Z: z})
+			}
+
+			data, errs := backend.GetBlockDataBatch(ctx, row)
+			for i, pos := range row {
+				if errors.Is(errs[i], ErrBlockNotFound) {
+					continue
+				}
+
+				if errs[i] != nil {
+					return nil, errs[i]
+				}
+
+				blocks[pos] = data[i]
+			}
+		}
 	}
 
-	return data, nil
+	return blocks, nil
+}
+
+func blockInRange(pos, min, max spatial.BlockPosition) bool {
+	return min.X <= pos.X && pos.X <= max.X &&
+		min.Y <= pos.Y && pos.Y <= max.Y &&
+		min.Z <= pos.Z && pos.Z <= max.Z
 }
 
 type World struct {
@@ -64,32 +131,123 @@ func NewWorldWithBackend(backend Backend) World {
 	}
 }
 
-func (w *World) GetBlock(pos spatial.BlockPosition) (*MapBlock, error) {
-	cachedBlock, ok := w.blockCache.Get(pos)
+// ListBlocks returns positions of all blocks stored in the map
+func (w *World) ListBlocks(ctx context.Context) ([]spatial.BlockPosition, error) {
+	it, err := w.backend.IterateBlocks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
 
-	if ok {
-		if cachedBlock == nil {
-			return nil, nil
-		}
-		return cachedBlock.(*MapBlock), nil
+	var positions []spatial.BlockPosition
+	for it.Next() {
+		positions = append(positions, it.Position())
 	}
 
-	data, err := w.backend.GetBlockData(pos)
-	if err != nil {
+	if err := it.Err(); err != nil {
 		return nil, err
 	}
 
-	if data == nil {
+	return positions, nil
+}
+
+// cachedResult converts an entry of the block cache into a result of
+// GetBlock. Missing blocks are cached as nil.
+func cachedResult(cached interface{}) (*MapBlock, error) {
+	switch cached := cached.(type) {
+	case *MapBlock:
+		return cached, nil
+	case *DecodeError:
+		return nil, cached
+	default:
+		return nil, ErrBlockNotFound
+	}
+}
+
+func (w *World) GetBlock(ctx context.Context, pos spatial.BlockPosition) (*MapBlock, error) {
+	cachedBlock, ok := w.blockCache.Get(pos)
+	recordCacheLookup(ok)
+
+	if ok {
+		return cachedResult(cachedBlock)
+	}
+
+	data, err := w.backend.GetBlockData(ctx, pos)
+	recordFetch(data, err)
+	if errors.Is(err, ErrBlockNotFound) {
 		w.blockCache.Add(pos, nil)
-		return nil, nil
+		return nil, ErrBlockNotFound
 	}
 
-	block, err := DecodeMapBlock(data)
 	if err != nil {
 		return nil, err
 	}
 
+	block, err := DecodeMapBlock(data)
+	if err != nil {
+		// Undecodable blocks are cached too, the data won't get any better
+		decodeErr := &DecodeError{Pos: pos, Err: err}
+		w.blockCache.Add(pos, decodeErr)
+		return nil, decodeErr
+	}
+
 	w.blockCache.Add(pos, block)
 
 	return block, nil
 }
+
+// GetBlocks fetches multiple blocks at once using the backend's batch API.
+// Results and errors are returned in the same order as positions; missing
+// blocks are reported as ErrBlockNotFound.
+func (w *World) GetBlocks(ctx context.Context, positions []spatial.BlockPosition) ([]*MapBlock, []error) {
+	blocks := make([]*MapBlock, len(positions))
+	errs := make([]error, len(positions))
+
+	var missing []spatial.BlockPosition
+	var missingIndices []int
+	for i, pos := range positions {
+		cachedBlock, ok := w.blockCache.Get(pos)
+		recordCacheLookup(ok)
+		if !ok {
+			missing = append(missing, pos)
+			missingIndices = append(missingIndices, i)
+			continue
+		}
+
+		blocks[i], errs[i] = cachedResult(cachedBlock)
+	}
+
+	if len(missing) == 0 {
+		return blocks, errs
+	}
+
+	data, dataErrs := w.backend.GetBlockDataBatch(ctx, missing)
+	for j, pos := range missing {
+		i := missingIndices[j]
+		recordFetch(data[j], dataErrs[j])
+
+		if errors.Is(dataErrs[j], ErrBlockNotFound) {
+			w.blockCache.Add(pos, nil)
+			errs[i] = ErrBlockNotFound
+			continue
+		}
+
+		if dataErrs[j] != nil {
+			errs[i] = dataErrs[j]
+			continue
+		}
+
+		block, err := DecodeMapBlock(data[j])
+		if err != nil {
+			decodeErr := &DecodeError{Pos: pos, Err: err}
+			w.blockCache.Add(pos, decodeErr)
+			errs[i] = decodeErr
+			continue
+		}
+
+		w.blockCache.Add(pos, block)
+		blocks[i] = block
+	}
+
+	return blocks, errs
+}
@@ -0,0 +1,34 @@
+package world
+
+import (
+	"context"
+	"testing"
+
+	"github.com/weqqr/panorama/pkg/spatial"
+)
+
+func TestGetBlocksInRegionEach(t *testing.T) {
+	backend := NewMemoryBackend()
+	data := readTestBlock(t, "block_v28.bin")
+	backend.SetBlock(spatial.BlockPosition{X: 0, Y: 0, Z: 0}, data)
+	backend.SetBlock(spatial.BlockPosition{X: 1, Y: 2, Z: 3}, data)
+	backend.SetBlock(spatial.BlockPosition{X: 4, Y: 0, Z: 0}, data)
+
+	for _, test := range []struct {
+		min, max spatial.BlockPosition
+		count    int
+	}{
+		{spatial.BlockPosition{X: 0, Y: 0, Z: 0}, spatial.BlockPosition{X: 3, Y: 3, Z: 3}, 2},
+		{spatial.BlockPosition{X: 0, Y: 0, Z: 0}, spatial.BlockPosition{X: 0, Y: 0, Z: 0}, 1},
+		{spatial.BlockPosition{X: 5, Y: 0, Z: 0}, spatial.BlockPosition{X: 0, Y: 3, Z: 3}, 0},
+		{spatial.BlockPosition{X: 0, Y: 3, Z: 0}, spatial.BlockPosition{X: 3, Y: 0, Z: 3}, 0},
+		{spatial.BlockPosition{X: 0, Y: 0, Z: 3}, spatial.BlockPosition{X: 3, Y: 3, Z: 0}, 0},
+	} {
+		blocks, err := GetBlocksInRegionEach(context.Background(), backend, test.min, test.max)
+		if err != nil {
+			t.Errorf("%v to %v: %v", test.min, test.max, err)
+		} else if len(blocks) != test.count {
+			t.Errorf("%v to %v: got %v blocks, want %v", test.min, test.max, len(blocks), test.count)
+		}
+	}
+}
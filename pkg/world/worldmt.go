@@ -0,0 +1,116 @@
+package world
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// readWorldMT parses `key = value` pairs from world.mt
+func readWorldMT(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	settings := make(map[string]string)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		// Skip comments and empty lines
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		settings[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+// openBackend creates the backend named in settings. maxConns limits
+// connections of database backends, and schema describes the blocks table of
+// PostgreSQL.
+func openBackend(worldPath string, settings map[string]string, maxConns int, schema PostgresSchema) (Backend, error) {
+	// Minetest defaults to SQLite if backend isn't specified
+	backend, ok := settings["backend"]
+	if !ok {
+		backend = "sqlite3"
+	}
+
+	// Constructors are called in separate branches so that a failed one
+	// doesn't produce a non-nil Backend holding a nil pointer
+	switch backend {
+	case "sqlite3":
+		sqlite, err := NewSQLiteBackend(filepath.Join(worldPath, "map.sqlite"))
+		if err != nil {
+			return nil, err
+		}
+		return sqlite, nil
+	case "leveldb":
+		leveldb, err := NewLevelDBBackend(filepath.Join(worldPath, "map.db"))
+		if err != nil {
+			return nil, err
+		}
+		return leveldb, nil
+	case "postgresql":
+		postgres, err := NewPostgresBackend(settings["pgsql_connection"], maxConns, schema)
+		if err != nil {
+			return nil, err
+		}
+		return postgres, nil
+	case "redis":
+		port, ok := settings["redis_port"]
+		if !ok {
+			port = "6379"
+		}
+		address := settings["redis_address"] + ":" + port
+		redis, err := NewRedisBackend(address, settings["redis_password"], settings["redis_hash"], maxConns)
+		if err != nil {
+			return nil, err
+		}
+		return redis, nil
+	default:
+		return nil, fmt.Errorf("unsupported backend: `%v`", backend)
+	}
+}
+
+// OpenBackend opens the map of the world located at path using the backend
+// specified in its world.mt. maxConns and schema are used the same way as by
+// NewPostgresBackend, so the world behaves the same whether it's opened with
+// a DSN or through world.mt.
+func OpenBackend(path string, maxConns int, schema PostgresSchema) (Backend, error) {
+	settings, err := readWorldMT(filepath.Join(path, "world.mt"))
+	if err != nil {
+		return nil, err
+	}
+
+	return openBackend(path, settings, maxConns, schema)
+}
+
+// OpenWorld opens the world located at path using the backend specified in
+// its world.mt, see OpenBackend
+func OpenWorld(path string, maxConns int, schema PostgresSchema) (*World, error) {
+	backend, err := OpenBackend(path, maxConns, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	world := NewWorldWithBackend(backend)
+
+	return &world, nil
+}